@@ -0,0 +1,33 @@
+package main
+
+import (
+	"regexp"
+
+	"golang.org/x/net/html"
+)
+
+// The account header of an EAC page, e.g. "Account: XXXX-1234" or
+// "Equity Award Account ...1234".
+var accountHeader = regexp.MustCompile(`Account(?: Number)?:?\s+((?:[X*.]+-?)?\d{3,})`)
+
+// Infer the account of an EAC page from its header, or "" if there
+// is none.
+func pageAccount(doc *html.Node) string {
+	m := accountHeader.FindStringSubmatch(textContent(doc))
+	if m == nil {
+		return ""
+	}
+	return m[1]
+}
+
+// Set key to value in the entries that don't already have it.
+func tag(entries []map[string]string, key, value string) {
+	if value == "" {
+		return
+	}
+	for _, e := range entries {
+		if e[key] == "" {
+			e[key] = value
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import "strings"
+
+// Actions that correct earlier entries. A reversal cancels the
+// entry; an adjustment or correction replaces its values.
+var correctionActions = map[string]bool{
+	"Adjustment": true,
+	"Correction": true,
+	"Reversal":   true,
+}
+
+// Link corrections to the entries they correct, when their
+// details reference them by "Original Date" (and, if given,
+// "Original Action"): the most recent such entry for the same
+// symbol is taken to be the one corrected, and its ID recorded as
+// the correction's "Corrects".
+func linkCorrections(entries []map[string]string) {
+	for i, c := range entries {
+		if !correctionActions[c["Action"]] || c["Corrects"] != "" || c["Original Date"] == "" {
+			continue
+		}
+		for j := i - 1; j >= 0; j-- {
+			e := entries[j]
+			if correctionActions[e["Action"]] || e["Symbol"] != c["Symbol"] || e["Date"] != c["Original Date"] {
+				continue
+			}
+			if a := c["Original Action"]; a != "" && !strings.EqualFold(a, e["Action"]) {
+				continue
+			}
+			c["Corrects"] = e["ID"]
+			break
+		}
+	}
+}
+
+// Apply the linked corrections to the entries, for the lot engine:
+// reversed entries are dropped, and adjusted ones replaced by a copy
+// with the adjustment's share counts, prices, and fees, so that
+// neither is counted twice. Corrections that aren't linked are left
+// for the engine to ignore.
+func applyCorrections(entries []map[string]string) []map[string]string {
+	fix := make(map[string]map[string]string)
+	for _, e := range entries {
+		if correctionActions[e["Action"]] && e["Corrects"] != "" {
+			fix[e["Corrects"]] = e
+		}
+	}
+	if len(fix) == 0 {
+		return entries
+	}
+
+	var out []map[string]string
+	for _, e := range entries {
+		c, ok := fix[e["ID"]]
+		switch {
+		case !ok:
+			out = append(out, e)
+		case c["Action"] == "Reversal":
+		default:
+			m := make(map[string]string)
+			for k, v := range e {
+				m[k] = v
+			}
+			for _, keys := range [][]string{sharesKeys, costKeys, saleKeys, {"Fees & Commissions"}} {
+				for _, k := range keys {
+					if v := c[k]; v != "" {
+						m[k] = v
+					}
+				}
+			}
+			out = append(out, m)
+		}
+	}
+	return out
+}
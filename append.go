@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Append the entries to file as NDJSON, a line each, skipping
+// those whose IDs (see identify) are already in it, so that it may
+// be fed the same or overlapping histories, as from cron, without
+// duplicating entries. The new lines are written together, so an
+// interrupted run leaves, at worst, a partial last line, which the
+// next run ignores. With -fields, the IDs must be among them.
+//
+// Entries that conflict with those in the file, having an ID
+// already there but different values, or restating an entry there
+// (see naturalKey), are reported rather than appended, and make for
+// an error once the rest are written. Given several runs of
+// entries, as when backfilling, each is appended in turn, as if
+// by itself, so that later runs may restate earlier ones.
+func appendNDJSON(file string, runs ...[]map[string]string) error {
+	if keys := fields(); keys != nil && !contains(keys, "ID") {
+		return errors.New("-append needs the ID among -fields")
+	}
+	old, err := readNDJSON(file)
+	if err != nil {
+		return err
+	}
+	byID := make(map[string]map[string]string)
+	byKey := make(map[string][]map[string]string)
+	for _, e := range old {
+		byID[e["ID"]] = e
+		byKey[naturalKey(e)] = append(byKey[naturalKey(e)], e)
+	}
+
+	var (
+		b         bytes.Buffer
+		n, total  int
+		conflicts int
+		enc       = json.NewEncoder(&b)
+	)
+	for _, entries := range runs {
+		entries = window(entries)
+		total += len(entries)
+		given := make(map[string]bool)
+		for _, e := range entries {
+			given[e["ID"]] = true
+		}
+		for _, e := range entries {
+			id := e["ID"]
+			// Compare the entry as it would be written, with its
+			// dates anchored and empty values as given by -empty, as
+			// those in the file were.
+			r := render([]map[string]string{e})[0]
+			w := stringMap(r.(map[string]interface{}))
+			if prev, ok := byID[id]; ok {
+				if d := differences(prev, w); d != "" {
+					log.Printf("%s: conflict: %s %s %s (ID %s): %s", file, e["Date"], e["Action"], e["Symbol"], id, d)
+					conflicts++
+				}
+				continue
+			}
+			if prev := restated(byKey[naturalKey(w)], given); prev != nil {
+				log.Printf("%s: conflict: %s %s %s (ID %s) restates ID %s: %s", file, e["Date"], e["Action"], e["Symbol"], id, prev["ID"], differences(prev, w))
+				conflicts++
+				continue
+			}
+			// Entries appended may be restated by later runs.
+			byID[id] = w
+			byKey[naturalKey(w)] = append(byKey[naturalKey(w)], w)
+			if err := enc.Encode(r); err != nil {
+				return err
+			}
+			n++
+		}
+	}
+
+	f, err := os.OpenFile(file, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+	out := b.Bytes()
+	if fi, err := f.Stat(); err == nil && fi.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := f.ReadAt(last, fi.Size()-1); err == nil && last[0] != '\n' {
+			out = append([]byte{'\n'}, out...)
+		}
+	}
+	if _, err := f.Write(out); err != nil {
+		f.Close()
+		return err
+	}
+	log.Printf("%s: appended %d of %d entries", file, n, total)
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if conflicts > 0 {
+		return fmt.Errorf("%s: %d conflicts", file, conflicts)
+	}
+	return nil
+}
+
+// Backfill an NDJSON file, as with -append, from archived output,
+// as written by earlier runs (see readArchive): the files matching
+// -from, in order of name, then any given. Entries in several
+// archives are appended once; those restating entries of earlier
+// archives are conflicts.
+func backfillCommand(args []string) error {
+	if *intoFlag == "" {
+		return errors.New("usage: eac2json backfill -into file.ndjson [-from pattern] [archive...]")
+	}
+	files := args
+	if *fromFlag != "" {
+		matches, err := filepath.Glob(*fromFlag)
+		if err != nil {
+			return err
+		}
+		if len(matches) == 0 {
+			return fmt.Errorf("no archives match %s", *fromFlag)
+		}
+		sort.Strings(matches)
+		files = append(matches, files...)
+	}
+	if len(files) == 0 {
+		return errors.New("no archives")
+	}
+
+	var runs [][]map[string]string
+	for _, file := range files {
+		b, err := readInput(file)
+		if err != nil {
+			return err
+		}
+		entries, err := readArchive(b)
+		if err != nil {
+			return fmt.Errorf("%s: %s", file, err)
+		}
+		runs = append(runs, entries)
+	}
+	return appendNDJSON(*intoFlag, runs...)
+}
+
+// Read the entries of an NDJSON file, if it exists.
+func readNDJSON(file string) ([]map[string]string, error) {
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	entries, err := parseNDJSON(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	return entries, nil
+}
+
+// Parse entries as NDJSON, as written by -append. Lines that don't
+// parse, as a last line cut short, are skipped.
+func parseNDJSON(r io.Reader) ([]map[string]string, error) {
+	var entries []map[string]string
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<20)
+	for line := 1; s.Scan(); line++ {
+		if len(bytes.TrimSpace(s.Bytes())) == 0 {
+			continue
+		}
+		var m map[string]interface{}
+		if err := json.Unmarshal(s.Bytes(), &m); err != nil {
+			log.Printf("line %d: skipping: %s", line, err)
+			continue
+		}
+		e := stringMap(m)
+		if e["ID"] == "" {
+			return nil, fmt.Errorf("line %d: entry without an ID", line)
+		}
+		entries = append(entries, e)
+	}
+	return entries, s.Err()
+}
+
+// Whether b holds NDJSON entries: its first line is an object with
+// an ID.
+func isNDJSON(b []byte) bool {
+	if i := bytes.IndexByte(b, '\n'); i >= 0 {
+		b = b[:i]
+	}
+	var e struct{ ID *string }
+	return json.Unmarshal(b, &e) == nil && e.ID != nil
+}
+
+// What a transaction is, apart from its amounts: an entry with the
+// same natural key as one already written, which is no longer
+// given, restates it, as Schwab sometimes does of old transactions.
+func naturalKey(e map[string]string) string {
+	return strings.Join([]string{e["Date"], e["Action"], e["Symbol"], grant(e), e["Account Name"]}, "\x00")
+}
+
+// The entry among prev that is no longer given, if any.
+func restated(prev []map[string]string, given map[string]bool) map[string]string {
+	for _, p := range prev {
+		if !given[p["ID"]] {
+			return p
+		}
+	}
+	return nil
+}
+
+// The differing values of two versions of an entry, as "key: old
+// -> new", less derived keys, and only those of -fields if given.
+// Keys missing from either are taken as empty.
+func differences(old, cur map[string]string) string {
+	keys := make(map[string]bool)
+	if fs := fields(); fs != nil {
+		for _, k := range fs {
+			keys[k] = true
+		}
+	} else {
+		for k := range old {
+			keys[k] = true
+		}
+		for k := range cur {
+			keys[k] = true
+		}
+	}
+	var diffs []string
+	for k := range keys {
+		if !derivedKeys[k] && old[k] != cur[k] {
+			diffs = append(diffs, fmt.Sprintf("%s: %q -> %q", k, old[k], cur[k]))
+		}
+	}
+	sort.Strings(diffs)
+	return strings.Join(diffs, ", ")
+}
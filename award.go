@@ -0,0 +1,66 @@
+package main
+
+// The keys of entries that describe their award's grant, rather
+// than the entry itself.
+var grantInfoKeys = []string{"Award Date", "Award Price", "Type"}
+
+// An award's lifecycle, as given by -group-by award: its grant,
+// as far as the entries tell, the entries acquiring shares under
+// it (vests, exercises, and so on), and those disposing of them.
+type award struct {
+	ID           string            `json:"Award ID"`
+	Grant        map[string]string `json:"Grant"`
+	Acquisitions []interface{}     `json:"Acquisitions"`
+	Dispositions []interface{}     `json:"Dispositions"`
+	Other        []interface{}     `json:"Other,omitempty"`
+}
+
+// Group the entries by award, in order of their first entries.
+// Entries without an award are grouped last, under an empty ID.
+func groupByAward(entries []map[string]string) []interface{} {
+	var (
+		ids    []string
+		awards = make(map[string]*award)
+	)
+	for _, e := range entries {
+		var id string
+		for _, k := range grantKeys {
+			if id = e[k]; id != "" {
+				break
+			}
+		}
+		a, ok := awards[id]
+		if !ok {
+			a = &award{ID: id, Grant: make(map[string]string),
+				Acquisitions: []interface{}{}, Dispositions: []interface{}{}}
+			awards[id] = a
+			if id != "" {
+				ids = append(ids, id)
+			}
+		}
+		for _, k := range grantInfoKeys {
+			if a.Grant[k] == "" && e[k] != "" {
+				a.Grant[k] = anchor(k, e[k])
+			}
+		}
+
+		r := render([]map[string]string{e})[0]
+		switch action := e["Action"]; {
+		case buyActions[action] || e["Record"] == "exercise":
+			a.Acquisitions = append(a.Acquisitions, r)
+		case sellActions[action] || saleActions[action]:
+			a.Dispositions = append(a.Dispositions, r)
+		default:
+			a.Other = append(a.Other, r)
+		}
+	}
+	if _, ok := awards[""]; ok {
+		ids = append(ids, "")
+	}
+
+	out := make([]interface{}, len(ids))
+	for i, id := range ids {
+		out[i] = awards[id]
+	}
+	return out
+}
@@ -0,0 +1,38 @@
+package main
+
+import "os"
+
+// The columns of the cost basis update file.
+var basisColumns = []string{
+	"Symbol",
+	"Date Acquired",
+	"Quantity",
+	"Cost Per Share",
+	"Cost Basis",
+}
+
+// Write a CSV file, suitable for a broker's cost basis update
+// form, giving the basis of the open lots deposited into the
+// brokerage account by lapses and exercises. Brokers often have
+// no (or zero) basis on record for these.
+func basisCommand(args []string) error {
+	b, err := book(args)
+	if err != nil {
+		return err
+	}
+
+	var records []map[string]string
+	for _, l := range b.Lots {
+		if l.Account != "brokerage" || l.Open.Sign() == 0 {
+			continue
+		}
+		records = append(records, map[string]string{
+			"Symbol":         l.Symbol,
+			"Date Acquired":  formatDate(l.Acquired),
+			"Quantity":       l.Open.String(),
+			"Cost Per Share": l.Basis.Quo(l.Open).Fixed(4),
+			"Cost Basis":     l.Basis.Fixed(2),
+		})
+	}
+	return writeCSV(os.Stdout, basisColumns, records)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// A blackout is a window in which trading is barred, as for
+// insiders around earnings, or a lockup.
+type blackout struct {
+	name       string
+	symbol     string // or "" for all
+	start, end time.Time
+}
+
+// Blackouts are read from a CSV file whose header names the
+// columns "Start" and "End" (dates, inclusive), and optionally
+// "Symbol" and "Name".
+type blackouts []blackout
+
+func readBlackouts(file string) (blackouts, error) {
+	f, err := openInput(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("%s: no header", file)
+	}
+
+	col := make(map[string]int)
+	for i, h := range rows[0] {
+		col[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	if _, ok := col["start"]; !ok {
+		return nil, fmt.Errorf("%s: no Start column", file)
+	}
+	if _, ok := col["end"]; !ok {
+		return nil, fmt.Errorf("%s: no End column", file)
+	}
+	get := func(row []string, k string) string {
+		if i, ok := col[k]; ok && i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+
+	var bs blackouts
+	for i, row := range rows[1:] {
+		b := blackout{name: get(row, "name"), symbol: get(row, "symbol")}
+		if b.start, err = parseDate(get(row, "start")); err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", file, i+2, err)
+		}
+		if b.end, err = parseDate(get(row, "end")); err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", file, i+2, err)
+		}
+		if b.name == "" {
+			b.name = formatDate(b.start) + "-" + formatDate(b.end)
+		}
+		bs = append(bs, b)
+	}
+	return bs, nil
+}
+
+// Annotate each sale with whether it fell in a blackout, as
+// "Blackout" ("yes" or "no"), and if so, which, as "Blackout
+// Window".
+func (bs blackouts) Annotate(entries []map[string]string) {
+	for _, e := range entries {
+		if !sellActions[e["Action"]] && !saleActions[e["Action"]] {
+			continue
+		}
+		date, err := parseDate(e["Date"])
+		if err != nil {
+			continue
+		}
+		e["Blackout"] = "no"
+		for _, b := range bs {
+			if b.symbol != "" && b.symbol != e["Symbol"] || date.Before(b.start) || date.After(b.end) {
+				continue
+			}
+			e["Blackout"] = "yes"
+			e["Blackout Window"] = b.name
+			break
+		}
+	}
+}
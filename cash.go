@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+
+	"golang.org/x/net/html"
+)
+
+// Parse the cash transaction history table, which records sale
+// proceeds, disbursements, and wire fees. Its layout is like the
+// transaction history's, but without details. The entries are
+// marked with the "Record" "cash".
+func parseCash(root *html.Node) ([]map[string]string, error) {
+	n, err := table(root)
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := row(n)
+	if err != nil {
+		return nil, fmt.Errorf("no header: %s", err)
+	}
+
+	var entries []map[string]string
+	for n.Sibling("tr"); n.Ok(); n.Sibling("tr") {
+		values, err := row(n)
+		if err != nil {
+			return nil, fmt.Errorf("bad row: %s", err)
+		}
+		if isDetail(n) {
+			n.Sibling("tr")
+		}
+
+		e := map[string]string{"Record": "cash"}
+		for i, k := range header {
+			if i < len(values) {
+				e[k] = values[i]
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// Check that the net proceeds of each sale from the EAC account
+// (e.g. a Forced Quick Sell) are credited to cash on the same day.
+func reconcileCash(entries []map[string]string) []error {
+	credits := make(map[string][]Decimal)
+	for _, e := range entries {
+		if e["Record"] != "cash" {
+			continue
+		}
+		if v, ok := amount(e, "Amount"); ok {
+			credits[e["Date"]] = append(credits[e["Date"]], v)
+		}
+	}
+
+	var errs []error
+	for _, e := range entries {
+		if !eacSales[e["Action"]] || e["Record"] == "cash" {
+			continue
+		}
+		v, ok := amount(e, "Amount")
+		if !ok {
+			continue
+		}
+		found := false
+		for i, c := range credits[e["Date"]] {
+			if c.Cmp(v) == 0 {
+				credits[e["Date"]] = append(credits[e["Date"]][:i], credits[e["Date"]][i+1:]...)
+				found = true
+				break
+			}
+		}
+		if !found {
+			errs = append(errs, fmt.Errorf("%s %s %s: no cash credit of %s", e["Date"], e["Action"], e["Symbol"], v))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+// Look up an amount in a bag; ok is false if it's absent or
+// doesn't parse.
+func amount(m map[string]string, k string) (Decimal, bool) {
+	s, ok := m[k]
+	if !ok || s == "" {
+		return Decimal{}, false
+	}
+	v, err := parseDecimal(s)
+	return v, err == nil
+}
+
+// Check that the shares sold at their sale prices, less fees,
+// add up to the net proceeds of a sale row. Rows without the
+// necessary values are not checked.
+func checkProceeds(row map[string]string, details []map[string]string) error {
+	net, ok := amount(row, "Amount")
+	if !ok || len(details) == 0 {
+		return nil
+	}
+	fees, _ := amount(row, "Fees & Commissions")
+
+	var gross Decimal
+	for _, d := range details {
+		shares, ok := amount(d, "Shares")
+		if !ok {
+			return nil
+		}
+		price, ok := amount(d, "Sale Price")
+		if !ok {
+			return nil
+		}
+		gross = gross.Add(shares.Mul(price))
+	}
+
+	cent := Decimal{big.NewRat(1, 100)}
+	if diff := gross.Sub(fees).Sub(net); diff.Abs().Cmp(cent) > 0 {
+		return fmt.Errorf("%s %s %s: gross %s less fees %s is %s, but net proceeds are %s",
+			row["Date"], row["Action"], row["Symbol"], gross, fees, gross.Sub(fees), net)
+	}
+
+	return nil
+}
+
+// Audit tracks the number of shares held in the EAC account,
+// per symbol. Shares are deposited before they are sold, or
+// journaled or disbursed to the brokerage account; the balance
+// should never go negative, should agree with the shares disbursed
+// when it is all moved out, and should be back at zero once
+// everything has been.
+type Audit struct {
+	balance map[string]Decimal
+}
+
+// Account for a history row. An error is returned if the balance
+// goes negative, or disagrees with the shares of a Forced
+// Disbursement, which moves out all that is left.
+func (a *Audit) Row(m map[string]string) error {
+	if a.balance == nil {
+		a.balance = make(map[string]Decimal)
+	}
+
+	q, ok := amount(m, "Quantity")
+	if !ok {
+		return nil
+	}
+
+	sym := m["Symbol"]
+	switch m["Action"] {
+	case "Deposit", "Dividend Reinvestment":
+	case "Forced Disbursement":
+		if bal := a.balance[sym]; bal.Cmp(q) != 0 {
+			a.balance[sym] = Decimal{}
+			return fmt.Errorf("%s %s %s: %s shares disbursed, but balance is %s", m["Date"], m["Action"], sym, q, bal)
+		}
+		q = q.Neg()
+	case "Forced Quick Sell", "Sell to Cover", "Quick Sell", "Journal":
+		q = q.Neg()
+	default:
+		return nil
+	}
+
+	a.balance[sym] = a.balance[sym].Add(q)
+	if bal := a.balance[sym]; bal.Sign() < 0 {
+		a.balance[sym] = Decimal{}
+		return fmt.Errorf("%s %s %s: balance is %s shares", m["Date"], m["Action"], sym, bal)
+	}
+
+	return nil
+}
+
+// Report symbols whose balance didn't come out even, in order.
+func (a *Audit) Done() []error {
+	var syms []string
+	for sym := range a.balance {
+		syms = append(syms, sym)
+	}
+	sort.Strings(syms)
+	var errs []error
+	for _, sym := range syms {
+		if bal := a.balance[sym]; bal.Sign() != 0 {
+			errs = append(errs, fmt.Errorf("%s: %s shares left in account", sym, bal))
+		}
+	}
+	return errs
+}
+
+// The detail keys known for each action. Those under "" are known
+// for all actions, as are taxes withheld, of any kind.
+var detailSchema = map[string][]string{
+	"":                      {"Reported Action"}, // see unalias
+	"Lapse":                 {"Award Date", "Award ID", "Fair Market Value", "Net Shares Deposited", "Cash in Lieu"},
+	"Release":               {"Award Date", "Award ID", "Fair Market Value", "Net Shares Deposited", "Cash in Lieu"},
+	"Deposit":               {"Award Date", "Award ID", "Purchase Price"},
+	"Forced Quick Sell":     {"Type", "Shares", "Sale Price", "Grant Id"},
+	"Sell to Cover":         {"Type", "Shares", "Sale Price", "Grant Id"},
+	"Quick Sell":            {"Type", "Shares", "Sale Price", "Grant Id"},
+	"Exer and Hold":         {"Award Date", "Award ID", "Type", "Shares", "Award Price", "Fair Market Value"},
+	"Sale":                  {"Award Date", "Award ID", "Award Price", "Type", "Shares", "Sale Price", "Subscription Date", "Subscription FMV", "Purchase Date", "Purchase Price", "Purchase FMV", "Grant Id"},
+	"Dividend Reinvestment": {"Shares", "Purchase Price"},
+	"Dividend Equivalent":   {"Award ID", "Shares", "Vest Date", "Fair Market Value"},
+	"DER":                   {"Award ID", "Shares", "Vest Date", "Fair Market Value"},
+	"Journal":               {"Shares", "Date"},
+	"Adjustment":            {"Original Date", "Original Action"},
+	"Correction":            {"Original Date", "Original Action"},
+	"Reversal":              {"Original Date", "Original Action"},
+}
+
+// Check that the history entries have no detail keys beyond those
+// known for their actions (see detailSchema), returning an error
+// listing the offenders.
+func checkSchema(entries []map[string]string) error {
+	known := make(map[string]map[string]bool)
+	for action, keys := range detailSchema {
+		known[action] = make(map[string]bool)
+		for _, k := range keys {
+			known[action][k] = true
+		}
+	}
+
+	seen := make(map[string]bool)
+	var offenders []string
+	for _, e := range entries {
+		action := e["Action"]
+		for k := range e {
+			if contains(historyColumns, k) || known[action][k] || known[""][k] || taxCategory(k) != "" {
+				continue
+			}
+			if o := fmt.Sprintf("%s %q", action, k); !seen[o] {
+				seen[o] = true
+				offenders = append(offenders, o)
+			}
+		}
+	}
+	if len(offenders) == 0 {
+		return nil
+	}
+	sort.Strings(offenders)
+	return fmt.Errorf("unknown detail keys: %s", strings.Join(offenders, ", "))
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAudit(t *testing.T) {
+	row := func(action, sym, q string) map[string]string {
+		return map[string]string{"Date": "03/15/2023", "Action": action, "Symbol": sym, "Quantity": q}
+	}
+	tests := []struct {
+		name string
+		rows []map[string]string
+		want []string // the errors of each row, then of Done
+	}{
+		{"even", []map[string]string{
+			row("Deposit", "GOOG", "40"),
+			row("Forced Quick Sell", "GOOG", "30"),
+			row("Forced Disbursement", "GOOG", "10"),
+		}, []string{"", "", ""}},
+		{"oversold", []map[string]string{
+			row("Deposit", "GOOG", "40"),
+			row("Sell to Cover", "GOOG", "50"),
+		}, []string{"", "03/15/2023 Sell to Cover GOOG: balance is -10 shares"}},
+		{"disbursed more than held", []map[string]string{
+			row("Deposit", "GOOG", "40"),
+			row("Forced Disbursement", "GOOG", "50"),
+		}, []string{"", "03/15/2023 Forced Disbursement GOOG: 50 shares disbursed, but balance is 40"}},
+		{"left over, in order", []map[string]string{
+			row("Deposit", "MSFT", "1"),
+			row("Deposit", "GOOGL", "2"),
+			row("Deposit", "GOOG", "3"),
+		}, []string{"", "", "", "GOOG: 3 shares left in account", "GOOGL: 2 shares left in account", "MSFT: 1 shares left in account"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var a Audit
+			var got []string
+			for _, r := range test.rows {
+				got = append(got, errString(a.Row(r)))
+			}
+			for _, err := range a.Done() {
+				got = append(got, errString(err))
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %q; want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
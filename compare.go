@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The years given by -year. It may be repeated for compare; other
+// commands take the last (as yearFlag).
+type yearsFlag []int
+
+var years yearsFlag
+
+func (y *yearsFlag) String() string {
+	var s []string
+	for _, n := range *y {
+		s = append(s, fmt.Sprint(n))
+	}
+	return strings.Join(s, ",")
+}
+
+func (y *yearsFlag) Set(s string) error {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return err
+	}
+	*y = append(*y, n)
+	*yearFlag = n
+	return nil
+}
+
+// Compare years: each year's summary (see summary) is reported,
+// followed by the change from each year to the next, in vested
+// shares, sale proceeds, taxes withheld, and realized gains.
+func compareCommand(args []string) error {
+	if len(years) < 2 {
+		return errors.New("usage: eac2json compare -year year -year year... [file...]")
+	}
+	q, err := parseQuery(*queryFlag)
+	if err != nil {
+		return err
+	}
+	entries, err := load(args)
+	if err != nil {
+		return err
+	}
+	c, err := loadWashConfig(*washConfigFlag)
+	if err != nil {
+		return err
+	}
+	b := new(Book)
+	c.Configure(b)
+	if err := b.Run(entries); err != nil {
+		return err
+	}
+
+	ys := append([]int(nil), years...)
+	sort.Ints(ys)
+	sums := make(map[int]*summary)
+	for _, y := range ys {
+		sums[y] = &summary{owner: fmt.Sprint(y)}
+	}
+	taxes := withholdings(entries)
+	for i, e := range entries {
+		date, err := parseDate(e["Date"])
+		if err != nil {
+			continue
+		}
+		if s := sums[date.Year()]; s != nil {
+			s.entries++
+			s.entry(e, taxes[i])
+		}
+	}
+	for _, sale := range b.Sales {
+		if s := sums[sale.Sold.Year()]; s != nil {
+			s.sale(sale)
+		}
+	}
+
+	var records []map[string]string
+	for _, y := range ys {
+		r := sums[y].record()
+		delete(r, "Owner")
+		delete(r, "Account Name")
+		r["Year"] = fmt.Sprint(y)
+		records = append(records, r)
+	}
+	for i := 1; i < len(ys); i++ {
+		from, to := sums[ys[i-1]], sums[ys[i]]
+		r := map[string]string{
+			"Record": "change",
+			"From":   fmt.Sprint(ys[i-1]),
+			"To":     fmt.Sprint(ys[i]),
+		}
+		change := func(k string, a, b Decimal, fixed bool) {
+			d := b.Sub(a)
+			if fixed {
+				r[k] = d.Fixed(2)
+			} else {
+				r[k] = d.String()
+			}
+			if a.Sign() != 0 {
+				r[k+" %"] = d.Quo(a.Abs()).Mul(decimal100).Fixed(1)
+			}
+		}
+		change("Vested Shares", from.vested, to.vested, false)
+		change("Proceeds", from.proceeds, to.proceeds, true)
+		change("Withheld", from.withheld, to.withheld, true)
+		change("Short-Term Gain", from.short, to.short, true)
+		change("Long-Term Gain", from.long, to.long, true)
+		change("Realized Gain", from.short.Add(from.long), to.short.Add(to.long), true)
+		records = append(records, r)
+	}
+	return emit(os.Stdout, records, q)
+}
+
+var decimal100, _ = parseDecimal("100")
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+)
+
+// Decimal is an exact decimal number. All values derived from
+// the history (sums, gains, basis adjustments) are computed with
+// Decimals so that tax numbers don't pick up floating point dust.
+// The zero value is 0.
+type Decimal struct {
+	r *big.Rat
+}
+
+func (d Decimal) rat() *big.Rat {
+	if d.r == nil {
+		return new(big.Rat)
+	}
+	return d.r
+}
+
+// Parse a dollar amount or share count as it appears in the
+// history table, e.g. "$1,234.56". Negative amounts are shown
+// in parentheses.
+func parseDecimal(s string) (Decimal, error) {
+	s = strings.TrimSpace(s)
+	neg := false
+	if strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")") {
+		neg = true
+		s = s[1 : len(s)-1]
+	}
+	if strings.HasPrefix(s, "-") {
+		neg = !neg
+		s = s[1:]
+	}
+	s = strings.TrimPrefix(s, "$")
+	s = strings.Replace(s, ",", "", -1)
+
+	// Rat.SetString also accepts fractions and exponents;
+	// we only want plain decimals.
+	if s == "" || strings.IndexFunc(s, func(c rune) bool {
+		return (c < '0' || c > '9') && c != '.'
+	}) >= 0 {
+		return Decimal{}, errors.New("bad number " + s)
+	}
+
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return Decimal{}, errors.New("bad number " + s)
+	}
+	if neg {
+		r.Neg(r)
+	}
+	return Decimal{r}, nil
+}
+
+func decimalInt(i int64) Decimal {
+	return Decimal{new(big.Rat).SetInt64(i)}
+}
+
+func (d Decimal) Add(e Decimal) Decimal {
+	return Decimal{new(big.Rat).Add(d.rat(), e.rat())}
+}
+
+func (d Decimal) Sub(e Decimal) Decimal {
+	return Decimal{new(big.Rat).Sub(d.rat(), e.rat())}
+}
+
+func (d Decimal) Mul(e Decimal) Decimal {
+	return Decimal{new(big.Rat).Mul(d.rat(), e.rat())}
+}
+
+// Quo returns d/e. The result is exact, though it may not have a
+// finite decimal representation; see String.
+func (d Decimal) Quo(e Decimal) Decimal {
+	return Decimal{new(big.Rat).Quo(d.rat(), e.rat())}
+}
+
+func (d Decimal) Neg() Decimal {
+	return Decimal{new(big.Rat).Neg(d.rat())}
+}
+
+func (d Decimal) Abs() Decimal {
+	return Decimal{new(big.Rat).Abs(d.rat())}
+}
+
+func (d Decimal) Cmp(e Decimal) int {
+	return d.rat().Cmp(e.rat())
+}
+
+func (d Decimal) Sign() int {
+	return d.rat().Sign()
+}
+
+// String formats d exactly, with as many decimal places as
+// needed, up to 20, after which it is rounded.
+func (d Decimal) String() string {
+	r := d.rat()
+	places := 0
+	for ; places < 20; places++ {
+		s := r.FloatString(places)
+		if x, _ := new(big.Rat).SetString(s); x.Cmp(r) == 0 {
+			return s
+		}
+	}
+	return r.FloatString(places)
+}
+
+// Fixed formats d rounded to the given number of places.
+func (d Decimal) Fixed(places int) string {
+	return d.rat().FloatString(places)
+}
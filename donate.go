@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// Suggest which open lots of -symbol to donate, -shares in all, on
+// -date (by default, today), writing them as CSV for the letter
+// specifying them to the broker. Only long-term lots are suggested,
+// as only their fair market value is deductible, and those with the
+// least basis per share first, as their gains are the greatest.
+// With -price, the value and gain of each are given too.
+func suggestDonation(args []string) error {
+	if *symbolFlag == "" || *sharesFlag == "" {
+		return errors.New("donate needs -symbol and -shares")
+	}
+	want, err := parseDecimal(*sharesFlag)
+	if err != nil {
+		return err
+	}
+	date, err := flagDate()
+	if err != nil {
+		return err
+	}
+	var price Decimal
+	havePrice := *priceFlag != ""
+	if havePrice {
+		if price, err = parseDecimal(*priceFlag); err != nil {
+			return err
+		}
+	}
+
+	b, err := book(args)
+	if err != nil {
+		return err
+	}
+	var (
+		cands []candidate
+		held  Decimal
+	)
+	for _, l := range b.Lots {
+		if l.Open.Sign() == 0 || l.Symbol != *symbolFlag || l.Account != "brokerage" {
+			continue
+		}
+		if !date.After(l.Since.AddDate(1, 0, 0)) {
+			continue
+		}
+		cands = append(cands, candidate{lot: l, cost: l.Basis.Quo(l.Open)})
+		held = held.Add(l.Open)
+	}
+	if held.Cmp(want) < 0 {
+		return fmt.Errorf("only %s shares of %s held long-term", held, *symbolFlag)
+	}
+	sort.SliceStable(cands, func(i, j int) bool {
+		return cands[i].cost.Cmp(cands[j].cost) < 0
+	})
+
+	columns := []string{"Account", "Symbol", "Date Acquired", "Shares", "Basis Per Share", "Cost Basis"}
+	if havePrice {
+		columns = append(columns, "Fair Market Value", "Gain")
+	}
+	var records []map[string]string
+	for _, c := range cands {
+		if want.Sign() == 0 {
+			break
+		}
+		n := c.lot.Open
+		if n.Cmp(want) > 0 {
+			n = want
+		}
+		want = want.Sub(n)
+
+		basis := c.cost.Mul(n)
+		r := map[string]string{
+			"Account":         c.lot.Account,
+			"Symbol":          c.lot.Symbol,
+			"Date Acquired":   formatDate(c.lot.Acquired),
+			"Shares":          n.String(),
+			"Basis Per Share": c.cost.Fixed(4),
+			"Cost Basis":      basis.Fixed(2),
+		}
+		if havePrice {
+			r["Fair Market Value"] = price.Mul(n).Fixed(2)
+			r["Gain"] = price.Mul(n).Sub(basis).Fixed(2)
+		}
+		records = append(records, r)
+	}
+	return writeCSV(os.Stdout, columns, records)
+}
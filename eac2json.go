@@ -14,6 +14,12 @@
 // NB! Eac2json is intended to assist in computing wash sales only.
 // It ignores certain entries that are not relevant for these purposes.
 //
+// The wash command matches sales against lots, first in, first out,
+// and reports wash sales. It accepts several files (e.g. the EAC
+// histories of several accounts, and brokerage histories given as JSON
+// entries with "Buy" and "Sell" actions) and applies the 30-day window
+// across all of them, as the rules require.
+//
 // What follows are the record types (as named by the "Action" field),
 // to the best of my understanding.
 //
@@ -23,31 +29,197 @@
 //
 // - "Forced Quick Sell": a sale of RSUs for tax purposes.
 //
+// - "Sell to Cover": the same, for plans configured for sell-to-cover.
+//
+// - "Quick Sell": a voluntary sale of shares from the EAC account.
+//
 // - "Lapse": a lapse of RSUs. The amount in "Net Shares Deposited"
 // goes into your Schwab brokerage account, the remainder is
 // sold for tax purposes, as chronicled by "Deposit" and
 // "Forced Quick Sell" entries.
 //
+// - "Release": a lapse, as labelled by newer plans. Its details are
+// renamed to match those of lapses.
+//
 // - "Exer and Hold": option (ISO or NSO) excercise-and-holds.
 // The shares are deposited into your broker account.
 //
 // - "Sale": Option or ESPP sales. (Exercise and sell.)
 //
+// - "Dividend Equivalent" (or "DER"): dividend equivalents on
+// unvested units, paid in cash or as units. Units make lots once
+// vested, as of their "Vest Date".
+//
+// - "Expiration", "Cancellation", "Forfeiture": options that lapse
+// unexercised, and grants cancelled or forfeited, e.g. on termination.
+//
+// Final statements, on termination, word some actions differently
+// (e.g. "Accelerated Vest", "Forced Exercise"); these are handled
+// as the actions above that they amount to.
+//
+// - "Adjustment", "Correction", "Reversal": corrections to earlier
+// entries, which are linked to them by ID as "Corrects" when their
+// details give the "Original Date".
+//
+// - "Journal": a transfer of shares to the brokerage account. These
+// are skipped unless -journals is given.
+//
+// - "Dividend": a cash dividend on shares held in the EAC account.
+//
+// - "Dividend Reinvestment": a dividend reinvested in shares, which
+// are held like any others, and so may replace shares sold at a loss.
+//
 package main
 
 import (
-	"bufio"
-	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"golang.org/x/net/html"
 )
 
+var (
+	emptyFlag = flag.String("empty", "string",
+		"represent empty cells as `mode`: string, omit, or null")
+	fieldsFlag = flag.String("fields", "",
+		"emit only the comma-separated `keys` of each entry")
+	queryFlag = flag.String("query", "",
+		"apply the jq-like `query` to the output")
+	offsetFlag = flag.Int("offset", 0,
+		"skip the first `n` entries; if negative, emit only the last -n")
+	limitFlag = flag.Int("limit", 0,
+		"emit at most `n` entries (0 for no limit)")
+	rulesFlag = flag.String("rules", "",
+		"load row handling rules from the JSON `file`")
+	washConfigFlag = flag.String("wash-config", "",
+		"configure wash sale detection from the JSON `file`")
+	lotsFlag = flag.String("lots", "",
+		"write the open lots, with adjusted basis, to `file` for a later run")
+	symbolFlag = flag.String("symbol", "",
+		"the `symbol` to sell")
+	sharesFlag = flag.String("shares", "",
+		"the number of `shares` to sell")
+	dateFlag = flag.String("date", "",
+		"the `date` of the sale, or of the prices for unrealized (default today)")
+	priceFlag = flag.String("price", "",
+		"the expected sale `price`")
+	pricesFlag = flag.String("prices", "",
+		"fill in missing prices, and value open lots for unrealized, from `source`: a CSV file of symbol,date,price, or stooq")
+	fxFlag = flag.String("fx", "",
+		"convert amounts using the CSV `file` of date,rate rows (home currency per dollar)")
+	currencyFlag = flag.String("currency", "FX",
+		"the home `currency`, for -fx")
+	securitiesFlag = flag.String("securities", "",
+		"add identifiers (CUSIP, ISIN, ...) from the CSV security master `file`")
+	blackoutsFlag = flag.String("blackouts", "",
+		"annotate sales falling in the trading blackout windows in the CSV `file`")
+	profileFlag = flag.String("profile", "tradelog",
+		"export in the format of `profile`: tradelog, gainskeeper, tracker, gnucash, hrblock, taxact, or a profile file")
+	fetchFlag = flag.String("fetch", "",
+		"download statements into `dir`")
+	cookieFlag = flag.String("cookie", "",
+		"the EAC session `cookie`, for downloads")
+	accountFlag = flag.String("account", "",
+		"tag entries with the account `name` (default from the page's account header)")
+	overlayFlag = flag.String("overlay", "",
+		"apply manual corrections, by entry ID, from the JSON or CSV `file`")
+	excludeFlag = flag.String("exclude-ids", "",
+		"drop the entries whose IDs are listed, one per line, in `file`")
+	envelopeFlag = flag.Bool("envelope", false,
+		"wrap the output in an object recording its schema version")
+	journalsFlag = flag.Bool("journals", false,
+		"emit journals to the brokerage account, with their details")
+	inLieuFlag = flag.String("cash-in-lieu", "sale",
+		"account for cash paid in lieu of fractional shares as `mode`: sale, or ignore")
+	strictFlag = flag.Bool("strict-schema", false,
+		"fail on detail keys not known for their actions")
+	timezoneFlag = flag.String("timezone", "",
+		"emit dates as RFC 3339 timestamps at midnight in `zone`, e.g. America/New_York")
+	manifestFlag = flag.String("manifest", "",
+		"write a manifest of the run (version, flags, rules, and input hashes) to `file`")
+	encryptFlag = flag.String("encrypt", "",
+		"encrypt the output to the comma-separated age `recipients` (age1...; not with -append, -lots, or -recover)")
+	signFlag = flag.String("sign", "",
+		"sign standard output with the PEM private `key` (not with -append or -split-by-symbol)")
+	signatureFlag = flag.String("signature", "eac2json.sig",
+		"write the signature made with -sign to `file`")
+	modelFlag = flag.String("model", "rows",
+		"emit entries as `model`: rows, as Schwab records them, or events, by what they mean")
+	groupFlag = flag.String("group-by", "",
+		"group the converted entries by `key`: award, with each award's grant, acquisitions, and dispositions")
+	coalesceFlag = flag.Bool("coalesce-vests", false,
+		"emit each lapse with its deposit and tax sale as a single vest")
+	recoverFlag = flag.String("recover", "",
+		"skip rows that can't be parsed, recording them, with their HTML, as JSON in `file`")
+	addrFlag = flag.String("addr", "localhost:8080",
+		"serve on `address`")
+	tokenFlag = flag.String("token", "",
+		"require serve clients to present the bearer `token`")
+	maxUploadFlag = flag.Int64("max-upload", 64<<20,
+		"limit serve requests to `n` bytes")
+	timeoutFlag = flag.Duration("timeout", time.Minute,
+		"limit serve conversions to `duration`")
+	maxJobsFlag = flag.Int("max-jobs", 8,
+		"limit serve to `n` conversions at once")
+	splitFlag = flag.Bool("split-by-symbol", false,
+		"write entries for each symbol to SYMBOL.json (SYMBOL.json.age with -encrypt) instead of standard output")
+	sourceFlag = flag.String("source", "",
+		"read sources as `kind` rather than sniffing it: html, csv, json, a plan administrator, or generic (see -mapping)")
+	mappingFlag = flag.String("mapping", "",
+		"map the columns and actions of -source generic CSV by the JSON or YAML `file`")
+	appendFlag = flag.String("append", "",
+		"append entries not already in the NDJSON `file` to it instead of writing standard output")
+	fromFlag = flag.String("from", "",
+		"backfill from the archives matching the `pattern`, e.g. archive/*.json")
+	intoFlag = flag.String("into", "",
+		"backfill into the NDJSON `file`")
+	preferFlag = flag.String("prefer", "",
+		"resolve transactions given by more than one file by `policy`: newest (the file given last), oldest, or error")
+	asOfFlag = flag.String("as-of", "",
+		"drop entries dated after `date`, as though run then")
+	dbFlag = flag.String("db", "",
+		"query the NDJSON `file` written by -append")
+	sqlSchemaFlag = flag.String("schema", "plain",
+		"write SQL in the `schema`: plain, with entries as JSON, or analytics, with typed columns, indices, and views")
+	formatFlag = flag.String("format", "json",
+		"write entries, and reports such as gains, as `format`: json, csv, tsv, or table")
+	fidFlag = flag.String("fid", "",
+		"name the financial institution `id` in QFX files, for Quicken (INTU.BID)")
+	orgFlag = flag.String("org", "Charles Schwab & Co., Inc.",
+		"name the financial institution `org` in QFX files, with -fid")
+	brokerIDFlag = flag.String("broker-id", "schwab.com",
+		"the `id` of the broker, or bank, in QFX files")
+	accountIDFlag = flag.String("account-id", "EAC",
+		"the `id` of the account in QFX files")
+	accountTypeFlag = flag.String("account-type", "investment",
+		"write QFX files for an account of `type`: investment, or checking, savings, or moneymrkt for the cash history")
+	csvDelimiterFlag = flag.String("csv-delimiter", ",",
+		"separate CSV fields with `char`, e.g. ; or tab")
+	csvQuoteAllFlag = flag.Bool("csv-quote-all", false,
+		"quote every CSV field")
+	csvCRLFFlag = flag.Bool("csv-crlf", false,
+		"end CSV lines with CRLF")
+)
+
+// The last -year given; see yearsFlag.
+var yearFlag = new(int)
+
+func init() {
+	flag.Var(hookFlag{}, "hook",
+		"run `action=command` for rows with the given action; may be repeated")
+	flag.Var(&years, "year",
+		"report only on sales in `year`; compare takes several")
+	flag.Var(&planned, "sell",
+		"simulate selling `\"shares symbol @ date @ price\"`; may be repeated")
+}
+
 var coreKeys = []string{
 	"Date",
 	"Description",
@@ -151,17 +323,83 @@ func (l *Ledger) Write(k, v string) {
 	l.e[k] = v
 }
 
+// Find the history table's anchor. Pages with navigation menus
+// may have more than one; the one followed by the most plausible
+// transaction table is taken.
 func findHistory(n *html.Node) *html.Node {
+	anchors := findAnchors(n, "History")
+	if len(anchors) < 2 {
+		return findAnchor(n, "History")
+	}
+
+	best, score, plausible := anchors[0], 0, 0
+	for _, a := range anchors {
+		s := scoreHistory(a)
+		if s > 0 {
+			plausible++
+		}
+		if s > score {
+			best, score = a, s
+		}
+	}
+	if plausible > 1 {
+		log.Printf("%d History anchors with tables; using the one scoring %d", plausible, score)
+	}
+	return best
+}
+
+// Score how much a History anchor looks like the history: 0 if it
+// has no table, more for a header with the expected columns, and
+// more for more rows.
+func scoreHistory(a *html.Node) int {
+	n, err := table(a)
+	if err != nil {
+		return 0
+	}
+	header, err := row(n)
+	if err != nil {
+		return 0
+	}
+	score := 1
+	for _, k := range header {
+		if k == "Date" || k == "Action" {
+			score += 10
+		}
+	}
+	for n.Sibling("tr"); n.Ok(); n.Sibling("tr") {
+		score++
+	}
+	return score
+}
+
+// Find all the anchors with the given name.
+func findAnchors(n *html.Node, name string) []*html.Node {
+	var anchors []*html.Node
+	if n.Type == html.ElementNode && n.Data == "a" {
+		for _, a := range n.Attr {
+			if a.Key == "name" && a.Val == name {
+				anchors = append(anchors, n)
+			}
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		anchors = append(anchors, findAnchors(c, name)...)
+	}
+	return anchors
+}
+
+// Find the anchor with the given name.
+func findAnchor(n *html.Node, name string) *html.Node {
 	if n.Type == html.ElementNode && n.Data == "a" {
 		for _, a := range n.Attr {
-			if a.Key == "name" && a.Val == "History" {
+			if a.Key == "name" && a.Val == name {
 				return n
 			}
 		}
 	}
 
 	for c := n.FirstChild; c != nil; c = c.NextSibling {
-		n := findHistory(c)
+		n := findAnchor(c, name)
 		if n != nil {
 			return n
 		}
@@ -170,6 +408,34 @@ func findHistory(n *html.Node) *html.Node {
 	return nil
 }
 
+// Grub out the actual table body from the root of a transaction
+// table, returning a node positioned at its first row.
+func table(root *html.Node) (*Node, error) {
+	n := &Node{root, root, nil, nil}
+	n.Child("table")
+	n.Child("tbody")
+	n.Child("tr")
+	n.Sibling("tr")
+	n.Child("td")
+	n.Child("table")
+	n.Child("tbody")
+
+	if !n.Ok() {
+		return nil, fmt.Errorf("bad table: %s", n.Err())
+	}
+	if n.Type != html.ElementNode || n.Data != "tbody" {
+		return nil, fmt.Errorf("bad table node %v type %d data %s", n, n.Type, n.Data)
+	}
+
+	n.Child("tr")
+
+	if !n.Ok() {
+		return nil, n.Err()
+	}
+
+	return n, nil
+}
+
 // Extract a regular data row.
 func row(n *Node) ([]string, error) {
 	n.Push()
@@ -193,8 +459,17 @@ func row(n *Node) ([]string, error) {
 	return values, nil
 }
 
-// Extract a "more details" row set.
-func more(n *Node) ([]map[string]string, error) {
+// Warnings about malformed input are reported through a warn
+// function, so that callers can give them context, or ignore them
+// when parsing speculatively.
+type warnFunc func(format string, args ...interface{})
+
+func ignore(string, ...interface{}) {}
+
+// Extract a "more details" row set. Rows whose cells don't pair up
+// with the headers are reported: short rows are dropped, and extra
+// cells are ignored.
+func more(n *Node, warn warnFunc) ([]map[string]string, error) {
 	n.Push()
 	defer n.Pop()
 
@@ -226,22 +501,43 @@ func more(n *Node) ([]map[string]string, error) {
 	for len(headers) > 0 && headers[len(headers)-1] == "" {
 		headers = headers[:len(headers)-1]
 	}
+	seen := make(map[string]bool)
+	for i, h := range headers {
+		switch {
+		case h == "":
+			warn("details: column %d has no header", i+1)
+		case seen[h]:
+			warn("details: duplicate column %q", h)
+		}
+		seen[h] = true
+	}
 
 	var entries []map[string]string
 
+	r := 0
 	for n.Sibling("tr"); n.Ok(); n.Sibling("tr") {
+		r++
 		n.Push()
 
-		m := make(map[string]string)
-
-		var i int
-		n.Child("td")
-		for i = 0; n.Ok() && i < len(headers); i++ {
-			m[headers[i]] = strings.TrimSpace(n.ChildText())
-			n.Sibling("td")
+		var cells []string
+		for n.Child("td"); n.Ok(); n.Sibling("td") {
+			cells = append(cells, strings.TrimSpace(n.ChildText()))
 		}
 
-		if i == len(headers) {
+		switch {
+		case len(cells) == 0:
+		case len(cells) < len(headers):
+			warn("details row %d: %d cells for %d columns; dropped", r, len(cells), len(headers))
+		default:
+			if strings.Join(cells[len(headers):], "") != "" {
+				warn("details row %d: %d cells for %d columns; extra cells ignored", r, len(cells), len(headers))
+			}
+			m := make(map[string]string)
+			for i, h := range headers {
+				if h != "" {
+					m[h] = cells[i]
+				}
+			}
 			entries = append(entries, m)
 		}
 
@@ -251,8 +547,11 @@ func more(n *Node) ([]map[string]string, error) {
 	return entries, nil
 }
 
-// Extract the second style of "more details" row.
-func more1(n *Node) (map[string]string, error) {
+// Extract the second style of "more details" row. Each cell holds
+// a key in bold, followed by its value; cells without a key are
+// skipped, and cells without a value, or keys given twice, are
+// reported.
+func more1(n *Node, warn warnFunc) (map[string]string, error) {
 	n.Push()
 	defer n.Pop()
 
@@ -276,12 +575,19 @@ func more1(n *Node) (map[string]string, error) {
 			key := strings.TrimSpace(n.ChildText())
 			n.Push()
 			n.Child("b")
+			ok := n.Ok()
 			value := strings.TrimSpace(n.Text())
 			n.Pop()
 
-			if key != "" {
-				entries[key] = value
+			if key == "" {
+				continue
+			}
+			if !ok {
+				warn("details: no value for %q", key)
+			} else if v, dup := entries[key]; dup && v != value {
+				warn("details: %q given as both %q and %q", key, v, value)
 			}
+			entries[key] = value
 		}
 
 		n.Pop()
@@ -290,66 +596,272 @@ func more1(n *Node) (map[string]string, error) {
 	return entries, nil
 }
 
-func usage() {
-	fmt.Fprintf(os.Stderr, "usage: eac2json [file]\n")
-	os.Exit(2)
+// The further tables of a "more details" pane, after the title
+// table and the details proper; e.g. a tax breakdown following
+// the lot table.
+func extraTables(n *Node) []*html.Node {
+	n.Push()
+	defer n.Pop()
+
+	n.Child("td")
+	n.Child("div")
+	n.Child("div")
+	n.Child("table")
+	n.Sibling("table")
+
+	var tables []*html.Node
+	for n.Sibling("table"); n.Ok(); n.Sibling("table") {
+		tables = append(tables, n.Node)
+	}
+	return tables
 }
 
-func main() {
-	log.SetFlags(0)
-	log.SetPrefix("eac2json: ")
+// The name of a table: its caption, or else the text (e.g. a
+// bold heading) preceding it.
+func caption(t *html.Node) string {
+	for c := t.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && c.Data == "caption" {
+			return textContent(c)
+		}
+	}
+	for c := t.PrevSibling; c != nil; c = c.PrevSibling {
+		if c.Type == html.ElementNode && c.Data == "table" {
+			break
+		}
+		if s := textContent(c); s != "" {
+			return s
+		}
+	}
+	return ""
+}
 
-	var r io.Reader
+// Extract the key/values of the further tables of a "more details"
+// pane, keyed by "Caption: Key". A table may have a header row of
+// bold keys, followed by rows of values (the keys of rows after the
+// first are numbered), or cells of bold keys followed by values.
+func extra(n *Node, warn warnFunc) map[string]string {
+	m := make(map[string]string)
+	for _, t := range extraTables(n) {
+		name := caption(t)
+		key := func(k string) string {
+			if name == "" {
+				return k
+			}
+			return name + ": " + k
+		}
 
-	switch len(os.Args) {
-	case 1:
-		r = os.Stdin
-	case 2:
-		file, err := os.Open(os.Args[1])
-		if err != nil {
-			log.Fatal(err)
+		type cell struct{ bold, rest string }
+		var rows [][]cell
+		var walk func(*html.Node)
+		walk = func(c *html.Node) {
+			switch {
+			case c.Type == html.ElementNode && c.Data == "caption":
+				return
+			case c.Type == html.ElementNode && c.Data == "tr":
+				var r []cell
+				for td := c.FirstChild; td != nil; td = td.NextSibling {
+					if td.Type != html.ElementNode || td.Data != "td" && td.Data != "th" {
+						continue
+					}
+					var x cell
+					all := textContent(td)
+					if b := findTag(td, "b"); b != nil {
+						x.bold = textContent(b)
+					}
+					x.rest = strings.TrimSpace(strings.TrimPrefix(all, x.bold))
+					r = append(r, x)
+				}
+				rows = append(rows, r)
+				return
+			}
+			for c := c.FirstChild; c != nil; c = c.NextSibling {
+				walk(c)
+			}
+		}
+		walk(t)
+
+		header := len(rows) > 1 && len(rows[0]) > 0
+		for i := 0; header && i < len(rows[0]); i++ {
+			if c := rows[0][i]; c.bold == "" || c.rest != "" {
+				header = false
+			}
+		}
+		if header {
+			for i, r := range rows[1:] {
+				if len(r) != len(rows[0]) {
+					warn("details table %q row %d: %d cells for %d columns", name, i+1, len(r), len(rows[0]))
+				}
+				for j, c := range r {
+					if j >= len(rows[0]) {
+						break
+					}
+					k := key(rows[0][j].bold)
+					if i > 0 {
+						k = fmt.Sprintf("%s (%d)", k, i+1)
+					}
+					m[k] = c.bold + c.rest
+				}
+			}
+			continue
+		}
+		for _, r := range rows {
+			for _, c := range r {
+				if c.bold != "" {
+					m[key(c.bold)] = c.rest
+				}
+			}
+		}
+	}
+	return m
+}
+
+// Find the first element with the given tag below n.
+func findTag(n *html.Node, tag string) *html.Node {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && c.Data == tag {
+			return c
+		}
+		if t := findTag(c, tag); t != nil {
+			return t
 		}
-		r = file
-	default:
-		usage()
 	}
+	return nil
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: eac2json [command] [flags] [file...]\n")
+	fmt.Fprintf(os.Stderr, "Commands:\n")
+	fmt.Fprintf(os.Stderr, "  wash\treport wash sales across all the files\n")
+	fmt.Fprintf(os.Stderr, "  8949\treport Form 8949 rows for each lot sold\n")
+	fmt.Fprintf(os.Stderr, "  schedd\treport Schedule D totals\n")
+	fmt.Fprintf(os.Stderr, "  gains\treport the gain or loss realized in -year, by sale and in total, as -format\n")
+	fmt.Fprintf(os.Stderr, "  unrealized\treport the unrealized gain or loss of the open lots at the -prices of -date, as -format\n")
+	fmt.Fprintf(os.Stderr, "  compare\tcompare vests, proceeds, withholding, and gains between each -year\n")
+	fmt.Fprintf(os.Stderr, "  withholding\treport taxes withheld per quarter and year\n")
+	fmt.Fprintf(os.Stderr, "  lots export\twrite the open lots, with adjusted basis, for a later run\n")
+	fmt.Fprintf(os.Stderr, "  lots import\tcarry exported lots through a later history, writing the lots left open\n")
+	fmt.Fprintf(os.Stderr, "  lots suggest-sale\tsuggest lots to sell to minimize tax\n")
+	fmt.Fprintf(os.Stderr, "  simulate\tsimulate the sales given by -sell, with the vests to come, reporting their tax character and wash sales\n")
+	fmt.Fprintf(os.Stderr, "  wash-risk\treport the recent and upcoming vests whose windows make sales at a loss wash sales\n")
+	fmt.Fprintf(os.Stderr, "  lots donate\tsuggest long-term lots to donate, with the greatest gains, as CSV for the broker\n")
+	fmt.Fprintf(os.Stderr, "  options\treport the in-the-money value and expirations of options at -price\n")
+	fmt.Fprintf(os.Stderr, "  forms\tcross-reference Forms 3921 and 3922, given as CSV, against exercises and ESPP purchases\n")
+	fmt.Fprintf(os.Stderr, "  basis\twrite a CSV cost basis update file for the broker\n")
+	fmt.Fprintf(os.Stderr, "  reconcile\treconcile a broker's cost basis export against the computed basis\n")
+	fmt.Fprintf(os.Stderr, "  export\texport CSV in the format of -profile\n")
+	fmt.Fprintf(os.Stderr, "  qfx\twrite a Quicken Web Connect file for the account given by -fid, -account-id, and -account-type\n")
+	fmt.Fprintf(os.Stderr, "  statements\tlist (and -fetch) the statements on a saved Statements page\n")
+	fmt.Fprintf(os.Stderr, "  household\tsummarize the accounts of a household manifest, with wash sales across them\n")
+	fmt.Fprintf(os.Stderr, "  sql\twrite the entries as an SQL script, e.g. for sqlite3, in the -schema\n")
+	fmt.Fprintf(os.Stderr, "  query\trun a canned query (holdings, gains, withholding, entries) on the -db, -as-of a date\n")
+	fmt.Fprintf(os.Stderr, "  backfill\tload archived output -from a pattern -into an NDJSON file, as with -append\n")
+	fmt.Fprintf(os.Stderr, "  migrate\tupgrade an archive written by an earlier release\n")
+	fmt.Fprintf(os.Stderr, "  convert\twrite archived output (JSON, NDJSON, CSV, or TSV) as -format\n")
+	fmt.Fprintf(os.Stderr, "  fixture\trender entries as a history page, for tests\n")
+	fmt.Fprintf(os.Stderr, "  selftest\tcheck the conversion of a directory of pages against their expected output\n")
+	fmt.Fprintf(os.Stderr, "  serve\tserve conversions over HTTP, synchronously or as jobs\n")
+	fmt.Fprintf(os.Stderr, "  native\tact as the native messaging host of a browser extension\n")
+	fmt.Fprintf(os.Stderr, "  normalize\twrite a saved page stripped of scripts, styles, and comments\n")
+	fmt.Fprintf(os.Stderr, "  schema\twrite the JSON Schema of the events interchange format (-model events)\n")
+	fmt.Fprintf(os.Stderr, "  validate\tvalidate files of events against the interchange format\n")
+	fmt.Fprintf(os.Stderr, "  verify\tverify the signature of output made with -sign\n")
+	flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, "Flags may also be set by EAC2JSON_<FLAG> environment variables.\n")
+	os.Exit(2)
+}
+
+// Set flags from EAC2JSON_* environment variables; e.g.
+// EAC2JSON_SPLIT_BY_SYMBOL sets -split-by-symbol. Flags given
+// on the command line take precedence. The values set are kept in
+// envValues.
+func envFlags() {
+	flag.VisitAll(func(f *flag.Flag) {
+		name := "EAC2JSON_" + strings.ToUpper(strings.Replace(f.Name, "-", "_", -1))
+		if v, ok := os.LookupEnv(name); ok {
+			if err := f.Value.Set(v); err != nil {
+				log.Fatalf("%s: %s", name, err)
+			}
+			envValues[f.Name] = f.Value.String()
+		}
+	})
+}
 
+var envValues = make(map[string]string)
+
+// Parse a saved EAC page into entries. The page may have a
+// transaction history, whose rows are handled according to the
+// rules, a cash transaction history, or both. Otherwise its iframes
+// are parsed instead, their documents found by frames (see
+// frameFunc); failing that, it may be an option exercise
+// confirmation, the options summary, or the unvested awards page.
+// Entries are tagged with the "Account Name" from the page's
+// header, if it has one.
+func parse(r io.Reader, rules []Rule, frames frameFunc) ([]map[string]string, error) {
+	return parseDepth(r, rules, frames, 0)
+}
+
+func parseDepth(r io.Reader, rules []Rule, frames frameFunc, depth int) ([]map[string]string, error) {
 	doc, err := html.Parse(r)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
+	normalize(doc)
 
-	// First find the transaction history table.
 	root := findHistory(doc)
-	if root == nil {
-		log.Fatal("no history")
+	cash := findAnchor(doc, "CashHistory")
+	if root == nil && cash == nil {
+		entries, err := parseFrames(doc, rules, frames, depth)
+		if err == errNoHistory {
+			if e := parseExercise(doc); e != nil {
+				return []map[string]string{e}, nil
+			}
+			if o := parseOptions(doc); o != nil {
+				tag(o, "Account Name", pageAccount(doc))
+				return o, nil
+			}
+			if u := parseUnvested(doc); u != nil {
+				tag(u, "Account Name", pageAccount(doc))
+				return u, nil
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		tag(entries, "Account Name", pageAccount(doc))
+		return entries, nil
 	}
 
-	// Grub out the actual table body
-	// from the root of the transaction table.
-	n := &Node{root, root, nil, nil}
-	n.Child("table")
-	n.Child("tbody")
-	n.Child("tr")
-	n.Sibling("tr")
-	n.Child("td")
-	n.Child("table")
-	n.Child("tbody")
-
-	if n.Type != html.ElementNode || n.Data != "tbody" {
-		log.Fatalf("bad table node %v type %d data %s", n, n.Type, n.Data)
+	var entries []map[string]string
+	if root != nil {
+		if entries, err = parseHistory(root, rules); err != nil {
+			return nil, err
+		}
 	}
+	if cash != nil {
+		c, err := parseCash(cash)
+		if err != nil {
+			return nil, fmt.Errorf("cash history: %s", err)
+		}
+		entries = append(entries, c...)
+		for _, err := range reconcileCash(entries) {
+			log.Print(err)
+		}
+	}
+	tag(entries, "Account Name", pageAccount(doc))
+	return entries, nil
+}
 
-	n.Child("tr")
-
-	if !n.Ok() {
-		log.Fatal(n.Err())
+// Parse the transaction history table.
+func parseHistory(root *html.Node, rules []Rule) ([]map[string]string, error) {
+	n, err := table(root)
+	if err != nil {
+		return nil, err
 	}
 
 	// The first row is the header
 	headerVals, err := row(n)
 	if err != nil {
-		log.Fatalf("no header: %s", err)
+		return nil, fmt.Errorf("no header: %s", err)
 	}
 
 	header := make(map[string]int)
@@ -357,109 +869,333 @@ func main() {
 		header[headerVals[i]] = i
 	}
 
-	var l Ledger
+	// Rows short of the header are padded.
+	fields := func(values []string) map[string]string {
+		m := make(map[string]string)
+		for k, i := range header {
+			if i < len(values) {
+				m[k] = values[i]
+			} else {
+				m[k] = ""
+			}
+		}
+		return m
+	}
 
-	for n.Sibling("tr"); n.Ok(); n.Sibling("tr") {
+	var (
+		l     Ledger
+		audit Audit
+	)
+
+	// Parse the row at n, and its details, advancing n past them.
+	parseRow := func() error {
 		// First try to extract a regular data row.
 		values, err := row(n)
 		if err != nil {
-			log.Fatalf("bad row: %s", err)
+			return fmt.Errorf("bad row: %s", err)
 		}
 
-		switch values[header["Action"]] {
-		case "Lapse":
-			l.Next()
-
-			for k, i := range header {
-				l.Write(k, values[i])
-			}
-
-			n.Sibling("tr")
-			entries, err := more1(n)
-			if err != nil {
-				log.Fatal(err)
-			}
-
-			for k, v := range entries {
-				l.Write(k, v)
-			}
-
-		case "Deposit", "Forced Quick Sell":
-			// Schwab sells shares for taxes by first depositing them
-			// to your EAC account,and then selling them.
-			// Remaining shares go into your brokerage account.
-
-			// Incorporate data into the row.
-			l.Next()
+		if len(values) != len(header) {
+			log.Printf("row %q: %d cells for %d columns", strings.Join(values, " "), len(values), len(header))
+		}
+		// Hooks are registered for actions as they are given; rules
+		// apply to the actions they amount to (see actionAliases).
+		action := fields(values)["Action"]
+		bag := func() map[string]string {
+			f := fields(values)
+			unalias(f)
+			return f
+		}
 
-			for k, i := range header {
-				l.Write(k, values[i])
-			}
+		if err := audit.Row(bag()); err != nil {
+			log.Print(err)
+		}
 
-			n.Sibling("tr")
-			entries, err := more(n)
+		if h, ok := hooks[action]; ok {
+			d, err := detail(n)
 			if err != nil {
-				log.Fatal(err)
-			}
-			if len(entries) != 1 {
-				log.Fatalf("Expected one row; got %d", len(entries))
+				return err
 			}
-
-			for k, v := range entries[0] {
-				l.Write(k, v)
-			}
-
-		case "Exer and Hold", "Sale":
-			// ISO exercise and hold. The details pane here may
-			// contain multiple entries that have different prices.
-			// We break this up into multiple entries.
-			//
-			// "Sale" is for other sales.
-
-			// XXX looks like ESPPs are sold directly in the brokeage account.
-			// XXX take care of this next
-
-			n.Sibling("tr")
-			entries, err := more(n)
+			entries, err := h(bag(), d)
 			if err != nil {
-				log.Fatal(err)
-			}
-			if len(entries) == 0 {
-				log.Fatalf("empty \"more details\" for Exer and Hold")
+				return err
 			}
-
 			for _, e := range entries {
 				l.Next()
-
-				for _, k := range coreKeys {
-					l.Write(k, values[header[k]])
-				}
-
 				for k, v := range e {
 					l.Write(k, v)
 				}
 			}
+			return nil
+		}
+
+		r := matchRule(rules, bag())
+		if r == nil {
+			return fmt.Errorf("unknown row type \"%s\"", action)
+		}
+		return r.Apply(&l, n, bag())
+	}
 
-		case "Journal":
-			// The next row holds more details, but it's not useful to us.
+	for n.Sibling("tr"); n.Ok(); n.Sibling("tr") {
+		at := *n
+		err := parseRow()
+		if err == nil {
+			continue
+		}
+		if *recoverFlag == "" {
+			return nil, err
+		}
+		// Skip the row and its details, resuming at the next.
+		*n = at
+		recoverRow(n, err)
+		for isDetail(n) {
 			n.Sibling("tr")
+		}
+	}
 
-		case "Forced Disbursement":
-			// Not relevant for our purposes. Also they don't contain any
-			// extra rows.
+	// Flush the last entry.
+	l.Next()
 
-		default:
-			log.Fatalf(fmt.Sprintf("unknown row type \"%s\"", values[header["Action"]]))
+	for _, err := range audit.Done() {
+		log.Print(err)
+	}
+
+	if *strictFlag {
+		if err := checkSchema(l.entries); err != nil {
+			return nil, err
 		}
 	}
 
 	// TODO: check that we're at the end of the table;
 	// that there are no more rows.
 
-	w := bufio.NewWriter(os.Stdout)
-	defer w.Flush()
-	enc := json.NewEncoder(w)
-	if err := enc.Encode(l.entries); err != nil {
+	return l.entries, nil
+}
+
+// Commands other than the default conversion, named by the first
+// argument.
+var commands = map[string]func(args []string) error{
+	"wash":        washCommand,
+	"8949":        form8949Command,
+	"schedd":      scheduleDCommand,
+	"gains":       gainsCommand,
+	"unrealized":  unrealizedCommand,
+	"simulate":    simulateCommand,
+	"wash-risk":   washRiskCommand,
+	"withholding": withholdingCommand,
+	"lots":        lotsCommand,
+	"basis":       basisCommand,
+	"export":      exportCommand,
+	"qfx":         qfxCommand,
+	"statements":  statementsCommand,
+	"household":   householdCommand,
+	"migrate":     migrateCommand,
+	"convert":     convertCommand,
+	"fixture":     fixtureCommand,
+	"selftest":    selftestCommand,
+	"verify":      verifyCommand,
+	"serve":       serveCommand,
+	"native":      nativeCommand,
+	"compare":     compareCommand,
+	"options":     optionsCommand,
+	"forms":       formsCommand,
+	"reconcile":   reconcileCommand,
+	"normalize":   normalizeCommand,
+	"schema":      schemaCommand,
+	"backfill":    backfillCommand,
+	"sql":         sqlCommand,
+	"query":       queryCommand,
+	"validate":    validateCommand,
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("eac2json: ")
+	flag.Usage = usage
+	envFlags()
+
+	cmd, args := convert, os.Args[1:]
+	if len(args) > 0 {
+		if c, ok := commands[args[0]]; ok {
+			cmd, args = c, args[1:]
+		}
+	}
+	flag.CommandLine.Parse(args)
+
+	switch *emptyFlag {
+	case "string", "omit", "null":
+	default:
+		log.Fatalf("bad -empty mode %q", *emptyFlag)
+	}
+	if *timezoneFlag != "" {
+		var err error
+		if location, err = time.LoadLocation(*timezoneFlag); err != nil {
+			log.Fatalf("bad -timezone: %s", err)
+		}
+	}
+	switch *modelFlag {
+	case "rows", "events":
+	default:
+		log.Fatalf("bad -model %q", *modelFlag)
+	}
+	switch *groupFlag {
+	case "":
+	case "award":
+		if *modelFlag != "rows" {
+			log.Fatal("-group-by cannot be used with -model")
+		}
+	default:
+		log.Fatalf("bad -group-by %q", *groupFlag)
+	}
+	switch *inLieuFlag {
+	case "sale", "ignore":
+	default:
+		log.Fatalf("bad -cash-in-lieu mode %q", *inLieuFlag)
+	}
+	switch *preferFlag {
+	case "", "newest", "oldest", "error":
+	default:
+		log.Fatalf("bad -prefer %q", *preferFlag)
+	}
+	switch *sqlSchemaFlag {
+	case "plain", "analytics":
+	default:
+		log.Fatalf("bad -schema %q", *sqlSchemaFlag)
+	}
+	switch c := csvDelimiter(); c {
+	case 0, '"', '\r', '\n', utf8.RuneError:
+		log.Fatalf("bad -csv-delimiter %q", *csvDelimiterFlag)
+	}
+	switch *accountTypeFlag {
+	case "investment", "checking", "savings", "moneymrkt":
+	default:
+		log.Fatalf("bad -account-type %q", *accountTypeFlag)
+	}
+	switch *formatFlag {
+	case "json":
+	case "csv", "tsv", "table":
+		if *queryFlag != "" {
+			log.Fatalf("-query cannot be used with -format %s", *formatFlag)
+		}
+	default:
+		log.Fatalf("bad -format %q", *formatFlag)
+	}
+	switch *sourceFlag {
+	case "", "html", "mhtml", "json", "equity-json", "csv", "xlsx", "text":
+	case "generic":
+		if *mappingFlag == "" {
+			log.Fatal("-source generic needs -mapping")
+		}
+	default:
+		if planNamed(*sourceFlag) == nil {
+			log.Fatalf("bad -source %q", *sourceFlag)
+		}
+	}
+
+	if *encryptFlag != "" {
+		// These write files of their own, in plain text.
+		if *appendFlag != "" {
+			log.Fatal("-append cannot be used with -encrypt")
+		}
+		if *lotsFlag != "" {
+			log.Fatal("-lots cannot be used with -encrypt")
+		}
+		if *recoverFlag != "" {
+			log.Fatal("-recover cannot be used with -encrypt")
+		}
+		var err error
+		if recipients, err = parseRecipients(*encryptFlag); err != nil {
+			log.Fatalf("bad -encrypt: %s", err)
+		}
+	}
+	if *signFlag != "" {
+		// The signature is of standard output, which these leave
+		// empty.
+		if *splitFlag {
+			log.Fatal("-split-by-symbol cannot be used with -sign")
+		}
+		if *appendFlag != "" {
+			log.Fatal("-append cannot be used with -sign")
+		}
+		var err error
+		if signer, err = readSigner(*signFlag); err != nil {
+			log.Fatalf("bad -sign: %s", err)
+		}
+	}
+
+	var d *diversion
+	if *signFlag != "" || *encryptFlag != "" {
+		var err error
+		if d, err = divert(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := cmd(flag.Args()); err != nil {
+		if d != nil {
+			d.Abort()
+		}
 		log.Fatal(err)
 	}
+	if d != nil {
+		if err := d.Done(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *recoverFlag != "" {
+		if err := writeRecovered(*recoverFlag); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *manifestFlag != "" {
+		if err := writeManifest(*manifestFlag, os.Args[1:]); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// Convert the history to JSON.
+func convert(args []string) error {
+	q, err := parseQuery(*queryFlag)
+	if err != nil {
+		return err
+	}
+	entries, err := load(args)
+	if err != nil {
+		return err
+	}
+	if *fxFlag != "" {
+		fx, err := readFX(*fxFlag)
+		if err != nil {
+			return err
+		}
+		if err := convertFX(entries, fx, *currencyFlag); err != nil {
+			return err
+		}
+	}
+	if *securitiesFlag != "" {
+		s, err := readSecurities(*securitiesFlag)
+		if err != nil {
+			return err
+		}
+		s.Enrich(entries)
+	}
+	if *blackoutsFlag != "" {
+		b, err := readBlackouts(*blackoutsFlag)
+		if err != nil {
+			return err
+		}
+		b.Annotate(entries)
+	}
+	if *splitFlag {
+		return split(entries, q)
+	}
+	if *appendFlag != "" {
+		return appendNDJSON(*appendFlag, entries)
+	}
+	switch *formatFlag {
+	case "csv", "tsv", "table":
+		entries = renderText(window(entries))
+		return report(os.Stdout, columns(entries), entries, q)
+	}
+	return emitEntries(os.Stdout, entries, q)
 }
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"log"
+	"math/rand"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// The rows of testdata/history.html, a line each, between the
+// lines opening and closing its table.
+func historyRows(t *testing.T) (head string, rows []string, tail string) {
+	t.Helper()
+	b, err := os.ReadFile("testdata/history.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	return lines[0], lines[1 : len(lines)-1], lines[len(lines)-1]
+}
+
+// Parse a page, returning what was logged while parsing it. A
+// panic fails the test.
+func parseLogged(t *testing.T, page string) (entries []map[string]string, logged string, err error) {
+	t.Helper()
+	var b bytes.Buffer
+	w := log.Writer()
+	log.SetOutput(&b)
+	defer log.SetOutput(w)
+	defer func() {
+		if r := recover(); r != nil {
+			t.Fatalf("panic: %v", r)
+		}
+	}()
+	rules, err := loadRules("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err = parse(strings.NewReader(page), rules, nil)
+	return entries, b.String(), err
+}
+
+// Mangled pages are reported, with an error or a warning, rather
+// than converted as if they were whole.
+func TestMangledPages(t *testing.T) {
+	head, rows, tail := historyRows(t)
+	page := func(rows []string) string {
+		return head + "\n" + strings.Join(rows, "\n") + "\n" + tail
+	}
+	whole := page(rows)
+	// Rows are the header, then each entry's row and its details:
+	// 1 and 2 are the Lapse, 3 and 4 the Deposit, 5 and 6 the
+	// Forced Quick Sell.
+	swap := func(i, j int) string {
+		rs := append([]string(nil), rows...)
+		rs[i], rs[j] = rs[j], rs[i]
+		return page(rs)
+	}
+	edit := func(i int, old, new string) string {
+		rs := append([]string(nil), rows...)
+		if !strings.Contains(rs[i], old) {
+			t.Fatalf("row %d has no %q", i, old)
+		}
+		rs[i] = strings.Replace(rs[i], old, new, 1)
+		return page(rs)
+	}
+
+	tests := []struct {
+		name string
+		page string
+		want string // in the error or the warnings
+	}{
+		{"truncated in a row", whole[:len(head)+len(rows[0])+len(rows[1])/2], "4 cells for 8 columns"},
+		{"truncated before details", page(rows[:4]), "Deposit: no details"},
+		{"details before their row", swap(3, 4), "bad row"},
+		{"no header", page(rows[1:]), "bad row"},
+		{"short row", edit(3, `<td><label></label></td><td><label></label></td><td><label></label></td></tr>`, `</tr>`), "5 cells for 8 columns"},
+		{"short details row", edit(4, `<td>123</td>`, ``), "2 cells for 3 columns"},
+		{"details without a value", edit(2, `<td><b>Award ID</b> 123</td>`, `<td>Award ID 123</td>`), "no value"},
+		{"duplicate details column", edit(6, `<td><b>Sale Price</b></td>`, `<td><b>Shares</b></td>`), "duplicate column"},
+		{"unknown action", edit(1, `Lapse`, `Lapsed`), "unknown row type"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, logged, err := parseLogged(t, test.page)
+			got := logged
+			if err != nil {
+				got += err.Error()
+			}
+			if !strings.Contains(got, test.want) {
+				t.Errorf("got error %v and warnings %q; want %q", err, logged, test.want)
+			}
+		})
+	}
+}
+
+// However a page is cut short or its rows shuffled, parsing it
+// doesn't panic.
+func TestMangledPagesDontPanic(t *testing.T) {
+	head, rows, tail := historyRows(t)
+	whole := head + "\n" + strings.Join(rows, "\n") + "\n" + tail
+	for i := 0; i < len(whole); i += 7 {
+		parseLogged(t, whole[:i])
+	}
+	for i := range rows {
+		for j := range rows {
+			rs := append([]string(nil), rows...)
+			rs[i], rs[j] = rs[j], rs[i]
+			parseLogged(t, head+"\n"+strings.Join(rs, "\n")+"\n"+tail)
+			parseLogged(t, head+"\n"+strings.Join(append(rs[:i:i], rs[i+1:]...), "\n")+"\n"+tail)
+		}
+	}
+}
+
+// A history in a frame is found however small the frame.
+func TestPixelFrame(t *testing.T) {
+	head, rows, tail := historyRows(t)
+	doc := html.EscapeString(head + "\n" + strings.Join(rows, "\n") + "\n" + tail)
+	page := `<html><body><iframe width="1" height="1" srcdoc="` + doc + `"></iframe></body></html>`
+	entries, logged, err := parseLogged(t, page)
+	if err != nil || len(entries) == 0 {
+		t.Errorf("got %d entries, error %v, and warnings %q", len(entries), err, logged)
+	}
+}
+
+// Fill a ledger with random bags, some of them empty, some with
+// keys written over, and with stray Nexts between them, returning
+// the bags written.
+func fillLedger(r *rand.Rand, l *Ledger) []map[string]string {
+	var bags []map[string]string
+	n := r.Intn(50)
+	for i := 0; i < n; i++ {
+		l.Next()
+		bag := make(map[string]string)
+		keys := r.Intn(4)
+		for j := 0; j < keys; j++ {
+			k, v := fmt.Sprint("k", r.Intn(5)), fmt.Sprint(i, j)
+			l.Write(k, v)
+			bag[k] = v
+		}
+		bags = append(bags, bag)
+		for r.Intn(3) == 0 {
+			l.Next() // an empty bag, as between a row and its details
+		}
+	}
+	l.Next()
+	return bags
+}
+
+// Entries come out of the ledger in the order they were written.
+func TestLedgerKeepsOrder(t *testing.T) {
+	for seed := int64(0); seed < 500; seed++ {
+		var l Ledger
+		bags := fillLedger(rand.New(rand.NewSource(seed)), &l)
+		var want []map[string]string
+		for _, b := range bags {
+			if len(b) > 0 {
+				want = append(want, b)
+			}
+		}
+		for i := 0; i < len(l.entries) && i < len(want); i++ {
+			if !reflect.DeepEqual(l.entries[i], want[i]) {
+				t.Fatalf("seed %d: entry %d is %v; want %v", seed, i, l.entries[i], want[i])
+			}
+		}
+	}
+}
+
+// Next never loses a bag that was written to, nor keeps one that
+// wasn't.
+func TestLedgerKeepsBags(t *testing.T) {
+	for seed := int64(0); seed < 500; seed++ {
+		var l Ledger
+		bags := fillLedger(rand.New(rand.NewSource(seed)), &l)
+		n := 0
+		for _, b := range bags {
+			if len(b) > 0 {
+				n++
+			}
+		}
+		if len(l.entries) != n {
+			t.Errorf("seed %d: %d entries for %d bags written", seed, len(l.entries), n)
+		}
+		for i, e := range l.entries {
+			if len(e) == 0 {
+				t.Errorf("seed %d: entry %d is empty", seed, i)
+			}
+		}
+	}
+}
+
+// Every row of a page is converted, in order, the last included.
+func TestParseKeepsRows(t *testing.T) {
+	head, rows, tail := historyRows(t)
+	entries, logged, err := parseLogged(t, head+"\n"+strings.Join(rows, "\n")+"\n"+tail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e["Action"])
+	}
+	want := []string{"Lapse", "Deposit", "Forced Quick Sell", "Exer and Hold", "Exer and Hold", "Sale"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, with warnings %q; want %q", got, logged, want)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+
+	"filippo.io/age"
+)
+
+// The recipients given by -encrypt, parsed before the command runs.
+var recipients []age.Recipient
+
+// Parse the comma-separated age X25519 recipients.
+func parseRecipients(list string) ([]age.Recipient, error) {
+	var rs []age.Recipient
+	for _, s := range strings.Split(list, ",") {
+		r, err := age.ParseX25519Recipient(strings.TrimSpace(s))
+		if err != nil {
+			return nil, err
+		}
+		rs = append(rs, r)
+	}
+	return rs, nil
+}
+
+// Encrypt data to recipients.
+func encrypt(data []byte) ([]byte, error) {
+	var b bytes.Buffer
+	w, err := age.Encrypt(&b, recipients...)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
@@ -0,0 +1,86 @@
+package main
+
+// A modelEvent is what an entry means, rather than how Schwab
+// records it; see -model. Entries whose actions aren't modelled
+// are given as "Other" events, with their actions.
+type modelEvent struct {
+	Type    string `json:"Type"`
+	Action  string `json:"Action,omitempty"`
+	Date    string `json:"Date"`
+	Symbol  string `json:"Symbol,omitempty"`
+	Shares  string `json:"Shares,omitempty"`
+	Price   string `json:"Price,omitempty"`
+	FMV     string `json:"Fair Market Value,omitempty"`
+	Amount  string `json:"Amount,omitempty"`
+	Fees    string `json:"Fees,omitempty"`
+	Award   string `json:"Award ID,omitempty"`
+	Account string `json:"Account Name,omitempty"`
+	Entry   string `json:"Entry,omitempty"` // the ID of the entry
+}
+
+// The event types of actions.
+var eventTypes = map[string]string{
+	"Lapse":                 "Vest",
+	"Release":               "Vest",
+	"Forced Quick Sell":     "TaxSale",
+	"Sell to Cover":         "TaxSale",
+	"Quick Sell":            "Sale",
+	"Sale":                  "Sale",
+	"Cash in Lieu":          "Sale",
+	"Sell":                  "Sale",
+	"Buy":                   "Buy",
+	"Exer and Hold":         "Exercise",
+	"Deposit":               "Transfer",
+	"Journal":               "Transfer",
+	"Dividend":              "Dividend",
+	"Dividend Reinvestment": "Dividend",
+	"Dividend Equivalent":   "Dividend",
+	"DER":                   "Dividend",
+}
+
+// Model the entries as events.
+func modelEvents(entries []map[string]string) []interface{} {
+	first := func(e map[string]string, keys ...string) string {
+		for _, k := range keys {
+			if e[k] != "" {
+				return e[k]
+			}
+		}
+		return ""
+	}
+
+	out := make([]interface{}, len(entries))
+	for i, e := range entries {
+		ev := &modelEvent{
+			Type:    eventTypes[e["Action"]],
+			Date:    anchor("Date", e["Date"]),
+			Symbol:  e["Symbol"],
+			Shares:  first(e, "Shares", "Quantity"),
+			Amount:  e["Amount"],
+			Fees:    e["Fees & Commissions"],
+			Award:   first(e, "Award ID", "Grant Id"),
+			Account: e["Account Name"],
+			Entry:   e["ID"],
+		}
+		if e["Record"] == "exercise" {
+			ev.Type = "Exercise"
+		}
+		switch ev.Type {
+		case "Vest":
+			ev.Price = e["Fair Market Value"]
+		case "Sale", "TaxSale":
+			ev.Price = first(e, "Sale Price", "Price")
+		case "Buy":
+			ev.Price = first(e, "Purchase Price", "Price")
+		case "Exercise":
+			ev.Price = first(e, "Award Price", "Strike Price")
+			ev.FMV = e["Fair Market Value"]
+		case "Dividend", "Transfer":
+			ev.Price = e["Purchase Price"]
+		case "":
+			ev.Type, ev.Action = "Other", e["Action"]
+		}
+		out[i] = ev
+	}
+	return out
+}
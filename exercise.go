@@ -0,0 +1,102 @@
+package main
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Keys identifying the grant, which vary by page.
+var grantKeys = []string{"Award ID", "Grant Id", "Grant ID", "Grant Number", "Award Number"}
+
+// The text content of a node.
+func textContent(n *html.Node) string {
+	var b strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// Parse an option exercise confirmation or details page. These
+// list their fields as two-cell label/value rows, including the
+// strike price, the fair market value at exercise, and the
+// ordinary income, which the history table leaves out. The result
+// is a single entry marked with the "Record" "exercise", or nil if
+// the page isn't one.
+func parseExercise(doc *html.Node) map[string]string {
+	e := make(map[string]string)
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "tr" {
+			var cells []*html.Node
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				if c.Type == html.ElementNode && (c.Data == "td" || c.Data == "th") {
+					cells = append(cells, c)
+				}
+			}
+			if len(cells) == 2 {
+				k := strings.TrimSuffix(textContent(cells[0]), ":")
+				if k != "" {
+					e[k] = textContent(cells[1])
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	if e["Exercise Date"] == "" {
+		return nil
+	}
+	e["Record"] = "exercise"
+	return e
+}
+
+func grant(e map[string]string) string {
+	for _, k := range grantKeys {
+		if v := e[k]; v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// Join exercise details onto the Exer and Hold entries with the
+// same date and grant, adding the keys they don't already have.
+// Joined exercise records are dropped.
+func joinExercises(entries []map[string]string) []map[string]string {
+	var out []map[string]string
+	for _, x := range entries {
+		if x["Record"] != "exercise" {
+			out = append(out, x)
+			continue
+		}
+
+		joined := false
+		for _, e := range entries {
+			if e["Action"] != "Exer and Hold" || e["Date"] != x["Exercise Date"] || grant(e) != grant(x) {
+				continue
+			}
+			for k, v := range x {
+				if _, ok := e[k]; !ok && k != "Record" {
+					e[k] = v
+				}
+			}
+			joined = true
+		}
+		if !joined {
+			out = append(out, x)
+		}
+	}
+	return out
+}
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// An export profile describes a CSV import format of some other
+// tool. Profiles of kind "trades" are given a record for each buy
+// and sell, with the keys
+//
+//	Date, Action (Buy or Sell), Symbol, Quantity, Price, Fees,
+//	Amount, Account
+//
+// Profiles of kind "gains" are given a record for each lot sold,
+// with the keys of the 8949 command. Profiles of kind "splits" are
+// given a record for each split of a double-entry transaction per
+// trade; see splits.
+//
+// Besides the built-in profiles, a profile may be given as a JSON
+// file, e.g.:
+//
+//	{
+//		"kind": "gains",
+//		"date": "2006-01-02",
+//		"columns": [
+//			{"header": "Security", "template": "{Symbol} ({Term})"},
+//			{"header": "Proceeds", "key": "Proceeds"}
+//		]
+//	}
+type profile struct {
+	Kind    string   `json:"kind"`
+	Date    string   `json:"date"` // Go time layout; default 01/02/2006
+	Columns []column `json:"columns"`
+
+	// For splits, the accounts to use; see splitAccounts.
+	Accounts map[string]string `json:"accounts"`
+}
+
+// A column has a header and takes its value from a record key, or
+// from a template in which {Key} is replaced by the key's value.
+type column struct {
+	Header   string `json:"header"`
+	Key      string `json:"key"`
+	Template string `json:"template"`
+}
+
+// Find a built-in profile, or load one from a file.
+func loadProfile(name string) (*profile, error) {
+	if p, ok := profiles[name]; ok {
+		return p, nil
+	}
+	b, err := readInput(name)
+	if os.IsNotExist(err) {
+		var names []string
+		for name := range profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown profile %q; have %s, or give a file", name, strings.Join(names, ", "))
+	}
+	if err != nil {
+		return nil, err
+	}
+	p := new(profile)
+	if err := json.Unmarshal(b, p); err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+	return p, nil
+}
+
+var profiles = map[string]*profile{
+	"tradelog": {
+		Kind: "trades",
+		Columns: []column{
+			{Header: "Date", Key: "Date"},
+			{Header: "Action", Key: "Action"},
+			{Header: "Symbol", Key: "Symbol"},
+			{Header: "Quantity", Key: "Quantity"},
+			{Header: "Price", Key: "Price"},
+			{Header: "Commission", Key: "Fees"},
+			{Header: "Net Amount", Key: "Amount"},
+		},
+	},
+	"gainskeeper": {
+		Kind: "trades",
+		Columns: []column{
+			{Header: "Account", Key: "Account"},
+			{Header: "Symbol", Key: "Symbol"},
+			{Header: "Trade Date", Key: "Date"},
+			{Header: "Action", Key: "Action"},
+			{Header: "Quantity", Key: "Quantity"},
+			{Header: "Price", Key: "Price"},
+			{Header: "Commission", Key: "Fees"},
+		},
+	},
+	// The generic format of portfolio trackers, such as Koinly's,
+	// Sharesight's, and Portfolio Performance's CSV imports.
+	"tracker": {
+		Kind: "trades",
+		Date: "2006-01-02",
+		Columns: []column{
+			{Header: "Date", Key: "Date"},
+			{Header: "Type", Key: "Action"},
+			{Header: "Asset", Key: "Symbol"},
+			{Header: "Quantity", Key: "Quantity"},
+			{Header: "Price", Key: "Price"},
+			{Header: "Fee", Key: "Fees"},
+			{Header: "Currency", Template: "USD"},
+		},
+	},
+	"gnucash": {
+		Kind: "splits",
+		Date: "2006-01-02",
+		Columns: []column{
+			{Header: "Date", Key: "Date"},
+			{Header: "Transaction ID", Key: "Transaction ID"},
+			{Header: "Description", Key: "Description"},
+			{Header: "Full Account Name", Key: "Account"},
+			{Header: "Amount Num.", Key: "Amount"},
+			{Header: "Value Num.", Key: "Value"},
+			{Header: "Rate/Price", Key: "Price"},
+			{Header: "Memo", Key: "Memo"},
+		},
+	},
+	"hrblock": {
+		Kind: "gains",
+		Columns: []column{
+			{Header: "Description of Property", Key: "Description"},
+			{Header: "Date Acquired", Key: "Date Acquired"},
+			{Header: "Date Sold", Key: "Date Sold"},
+			{Header: "Sales Price", Key: "Proceeds"},
+			{Header: "Cost or Other Basis", Key: "Cost Basis"},
+			{Header: "Adjustment Code", Key: "Code"},
+			{Header: "Adjustment Amount", Key: "Adjustment"},
+			{Header: "Term", Key: "Term"},
+		},
+	},
+	"taxact": {
+		Kind: "gains",
+		Date: "01/02/06",
+		Columns: []column{
+			{Header: "Description", Key: "Description"},
+			{Header: "DateAcquired", Key: "Date Acquired"},
+			{Header: "DateSold", Key: "Date Sold"},
+			{Header: "SalesProceeds", Key: "Proceeds"},
+			{Header: "CostBasis", Key: "Cost Basis"},
+			{Header: "WashSaleCode", Key: "Code"},
+			{Header: "WashSaleAmount", Key: "Adjustment"},
+		},
+	},
+}
+
+// Records of each buy and sell in the entries, with the action of
+// the entry each came from as its "Entry Action".
+func trades(entries []map[string]string) ([]map[string]string, error) {
+	var records []map[string]string
+	for _, e := range entries {
+		action := e["Action"]
+		if action == "Lot" {
+			continue
+		}
+		date, err := parseDate(e["Date"])
+		if err != nil {
+			return nil, err
+		}
+
+		if buyActions[action] || saleActions[action] {
+			acquired := date
+			if d, err := parseDate(e["Purchase Date"]); err == nil && saleActions[action] {
+				acquired = d
+			}
+			l, err := newLot(e, acquired)
+			if err != nil {
+				return nil, err
+			}
+			// Lots of no shares, as of vests withheld in full,
+			// are skipped, as by Book.Run.
+			if l.Shares.Sign() == 0 {
+				continue
+			}
+			records = append(records, map[string]string{
+				"Date":         formatDate(acquired),
+				"Action":       "Buy",
+				"Symbol":       l.Symbol,
+				"Quantity":     l.Shares.String(),
+				"Price":        l.Basis.Quo(l.Shares).Fixed(4),
+				"Fees":         "0.00",
+				"Amount":       l.Basis.Fixed(2),
+				"Account":      l.Account,
+				"Entry Action": action,
+			})
+		}
+
+		if sellActions[action] || saleActions[action] {
+			shares, ok := first(e, sharesKeys)
+			if !ok {
+				return nil, fmt.Errorf("%s %s: no share count", e["Date"], action)
+			}
+			price, ok := first(e, saleKeys)
+			if !ok {
+				return nil, fmt.Errorf("%s %s: no sale price", e["Date"], action)
+			}
+			fees, _ := amount(e, "Fees & Commissions")
+			records = append(records, map[string]string{
+				"Date":         formatDate(date),
+				"Action":       "Sell",
+				"Symbol":       e["Symbol"],
+				"Quantity":     shares.String(),
+				"Price":        price.Fixed(4),
+				"Fees":         fees.Fixed(2),
+				"Amount":       shares.Mul(price).Sub(fees).Fixed(2),
+				"Account":      account(action),
+				"Entry Action": action,
+			})
+		}
+	}
+	return records, nil
+}
+
+// Records of each lot sold, as given by the 8949 command.
+func gains(args []string) ([]map[string]string, error) {
+	b, err := book(args)
+	if err != nil {
+		return nil, err
+	}
+	return gainRecords(form8949(b.Year())), nil
+}
+
+func gainRecords(rows []row8949) []map[string]string {
+	var records []map[string]string
+	for _, r := range rows {
+		term := "Short-term"
+		if r.sale.Long() {
+			term = "Long-term"
+		}
+		records = append(records, map[string]string{
+			"Term":          term,
+			"Description":   fmt.Sprintf("%s sh. %s", r.sale.Shares, r.sale.Symbol),
+			"Symbol":        r.sale.Symbol,
+			"Shares":        r.sale.Shares.String(),
+			"Date Acquired": formatDate(r.sale.Acquired),
+			"Date Sold":     formatDate(r.sale.Sold),
+			"Proceeds":      r.proceeds.Fixed(2),
+			"Cost Basis":    r.basis.Fixed(2),
+			"Code":          r.code,
+			"Adjustment":    r.adjustment.Fixed(2),
+			"Gain or Loss":  r.gain.Fixed(2),
+			"Source":        r.sale.Source,
+		})
+	}
+	return records
+}
+
+// Export trades or gains as CSV in the format of -profile.
+func exportCommand(args []string) error {
+	p, err := loadProfile(*profileFlag)
+	if err != nil {
+		return err
+	}
+
+	var records []map[string]string
+	switch p.Kind {
+	case "trades":
+		var entries []map[string]string
+		if entries, err = load(args); err == nil {
+			records, err = trades(entries)
+		}
+	case "gains":
+		records, err = gains(args)
+	case "splits":
+		var entries []map[string]string
+		if entries, err = load(args); err == nil {
+			if records, err = trades(entries); err == nil {
+				records = splits(records, p.Accounts)
+			}
+		}
+	default:
+		err = fmt.Errorf("bad profile kind %q", p.Kind)
+	}
+	if err != nil {
+		return err
+	}
+
+	var headers []string
+	rows := make([]map[string]string, len(records))
+	for _, c := range p.Columns {
+		headers = append(headers, c.Header)
+	}
+	for i, r := range records {
+		row := make(map[string]string)
+		for _, c := range p.Columns {
+			row[c.Header] = p.value(c, r)
+		}
+		rows[i] = row
+	}
+	return writeCSV(os.Stdout, headers, rows)
+}
+
+// The default accounts for splits. Accounts are templates, like
+// columns; e.g. {Symbol} is replaced by the symbol traded. Vests
+// and exercises are booked as income; the proceeds of the sales
+// that pay withholding taxes (see taxSales) go to them.
+var splitAccounts = map[string]string{
+	"stock":  "Assets:Investments:{Account}:{Symbol}",
+	"cash":   "Assets:Investments:{Account}:Cash",
+	"income": "Income:Equity Compensation",
+	"fees":   "Expenses:Commissions",
+	"taxes":  "Expenses:Taxes:Withholding",
+}
+
+// Turn trades into the splits of double-entry transactions, each
+// with the keys
+//
+//	Date, Transaction ID, Description, Account, Amount (shares,
+//	for the stock account), Value, Price, Memo
+func splits(trades []map[string]string, accounts map[string]string) []map[string]string {
+	acct := func(name string, t map[string]string) string {
+		a, ok := accounts[name]
+		if !ok {
+			a = splitAccounts[name]
+		}
+		return expand(a, t, func(_, v string) string { return v })
+	}
+
+	var records []map[string]string
+	for i, t := range trades {
+		id := fmt.Sprint(i + 1)
+		split := func(account, amount, value, price string) {
+			records = append(records, map[string]string{
+				"Date":           t["Date"],
+				"Transaction ID": id,
+				"Description":    t["Action"] + " " + t["Quantity"] + " " + t["Symbol"],
+				"Account":        account,
+				"Amount":         amount,
+				"Value":          value,
+				"Price":          price,
+				"Memo":           t["Account"],
+			})
+		}
+		neg := func(s string) string {
+			d, _ := parseDecimal(s)
+			return d.Neg().Fixed(2)
+		}
+
+		shares, _ := parseDecimal(t["Quantity"])
+		price, _ := parseDecimal(t["Price"])
+		gross := shares.Mul(price).Fixed(2)
+
+		switch t["Action"] {
+		case "Buy":
+			split(acct("stock", t), t["Quantity"], gross, t["Price"])
+			split(acct("income", t), neg(gross), neg(gross), "")
+		case "Sell":
+			split(acct("stock", t), shares.Neg().String(), neg(gross), t["Price"])
+			if fees, _ := parseDecimal(t["Fees"]); fees.Sign() != 0 {
+				split(acct("fees", t), t["Fees"], t["Fees"], "")
+			}
+			to := "cash"
+			if taxSales[t["Entry Action"]] {
+				to = "taxes"
+			}
+			split(acct(to, t), t["Amount"], t["Amount"], "")
+		}
+	}
+	return records
+}
+
+// The value of a column for a record.
+func (p *profile) value(c column, r map[string]string) string {
+	if c.Template == "" {
+		return p.format(c.Key, r[c.Key])
+	}
+	return expand(c.Template, r, p.format)
+}
+
+// Expand a template, replacing each {Key} with the record's
+// value for it, as formatted by format.
+func expand(t string, r map[string]string, format func(key, v string) string) string {
+	var b strings.Builder
+	for {
+		i := strings.Index(t, "{")
+		j := strings.Index(t, "}")
+		if i < 0 || j < i {
+			break
+		}
+		key := t[i+1 : j]
+		b.WriteString(t[:i])
+		b.WriteString(format(key, r[key]))
+		t = t[j+1:]
+	}
+	b.WriteString(t)
+	return b.String()
+}
+
+// Format a value for the profile; dates are given in its layout.
+func (p *profile) format(key, v string) string {
+	if p.Date != "" && strings.HasPrefix(key, "Date") {
+		if t, err := parseDate(v); err == nil {
+			return t.Format(p.Date)
+		}
+	}
+	return v
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Column names used by Schwab's CSV and XLSX exports, mapped to
+// the keys used in the history page. Others are split into words.
+var exportKeys = map[string]string{
+	"FeesAndCommissions":          "Fees & Commissions",
+	"DisbursementElection":        "Disbursement Election",
+	"AwardId":                     "Award ID",
+	"AwardID":                     "Award ID",
+	"GrantId":                     "Grant Id",
+	"FairMarketValuePrice":        "Fair Market Value",
+	"FairMarketValue":             "Fair Market Value",
+	"PurchaseFairMarketValue":     "Purchase FMV",
+	"SubscriptionFairMarketValue": "Subscription FMV",
+	"SharesSoldWithheldForTaxes":  "Shares Sold/Withheld for Taxes",
+}
+
+func exportKey(name string) string {
+	name = strings.TrimSpace(name)
+	if k, ok := exportKeys[name]; ok {
+		return k
+	}
+	if strings.Contains(name, " ") {
+		return name
+	}
+	var b strings.Builder
+	for i, c := range name {
+		if i > 0 && unicode.IsUpper(c) {
+			b.WriteByte(' ')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// Parse a transaction history exported by Schwab as CSV.
+func parseExportCSV(r io.Reader) ([]map[string]string, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	rows, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	return exportEntries(rows)
+}
+
+// Parse a transaction history exported by Schwab as XLSX. Only
+// the first sheet is read.
+func parseExportXLSX(b []byte) ([]map[string]string, error) {
+	z, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		return nil, err
+	}
+
+	read := func(name string, v interface{}) error {
+		for _, f := range z.File {
+			if f.Name == name {
+				rc, err := f.Open()
+				if err != nil {
+					return err
+				}
+				defer rc.Close()
+				return xml.NewDecoder(rc).Decode(v)
+			}
+		}
+		return nil
+	}
+
+	var shared struct {
+		SI []struct {
+			T string `xml:"t"`
+			R []struct {
+				T string `xml:"t"`
+			} `xml:"r"`
+		} `xml:"si"`
+	}
+	if err := read("xl/sharedStrings.xml", &shared); err != nil {
+		return nil, err
+	}
+	strs := make([]string, len(shared.SI))
+	for i, si := range shared.SI {
+		strs[i] = si.T
+		for _, r := range si.R {
+			strs[i] += r.T
+		}
+	}
+
+	var sheet struct {
+		Rows []struct {
+			Cells []struct {
+				Ref    string `xml:"r,attr"`
+				Type   string `xml:"t,attr"`
+				V      string `xml:"v"`
+				Inline string `xml:"is>t"`
+			} `xml:"c"`
+		} `xml:"sheetData>row"`
+	}
+	if err := read("xl/worksheets/sheet1.xml", &sheet); err != nil {
+		return nil, err
+	}
+
+	var rows [][]string
+	for _, r := range sheet.Rows {
+		var row []string
+		for _, c := range r.Cells {
+			v := c.V
+			switch c.Type {
+			case "s":
+				i, err := strconv.Atoi(c.V)
+				if err != nil || i >= len(strs) {
+					return nil, fmt.Errorf("bad shared string %q", c.V)
+				}
+				v = strs[i]
+			case "inlineStr":
+				v = c.Inline
+			}
+			// Cells may be missing; place them by column.
+			col := 0
+			for _, ch := range c.Ref {
+				if ch < 'A' || ch > 'Z' {
+					break
+				}
+				col = col*26 + int(ch-'A') + 1
+			}
+			for col > 0 && len(row) < col-1 {
+				row = append(row, "")
+			}
+			row = append(row, v)
+		}
+		rows = append(rows, row)
+	}
+	return exportEntries(rows)
+}
+
+// Rename the keys of an exported transaction or detail.
+func exportMap(m map[string]string) map[string]string {
+	e := make(map[string]string)
+	for k, v := range m {
+		e[exportKey(k)] = v
+	}
+	return e
+}
+
+// The entries for an exported transaction and its details: a
+// single detail is merged into it; several are split into an entry
+// each, as with the history page.
+func exportTransaction(t map[string]string, details []map[string]string) []map[string]string {
+	switch len(details) {
+	case 0:
+		return []map[string]string{t}
+	case 1:
+		for k, v := range details[0] {
+			t[k] = v
+		}
+		return []map[string]string{t}
+	}
+
+	var entries []map[string]string
+	for _, d := range details {
+		e := make(map[string]string)
+		for _, k := range coreKeys {
+			e[k] = t[k]
+		}
+		for k, v := range d {
+			e[k] = v
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// Turn exported rows into entries. The first row is the header.
+// Rows without a date or action hold the details of the preceding
+// transaction.
+func exportEntries(rows [][]string) ([]map[string]string, error) {
+	if len(rows) == 0 {
+		return nil, errors.New("empty export")
+	}
+	header := make([]string, len(rows[0]))
+	for i, h := range rows[0] {
+		header[i] = exportKey(h)
+	}
+
+	var (
+		entries []map[string]string
+		parent  map[string]string
+		details []map[string]string
+	)
+	flush := func() {
+		if parent != nil {
+			entries = append(entries, exportTransaction(parent, details)...)
+		}
+		parent, details = nil, nil
+	}
+
+	for _, row := range rows[1:] {
+		m := make(map[string]string)
+		for i, v := range row {
+			if i < len(header) && strings.TrimSpace(v) != "" {
+				m[header[i]] = strings.TrimSpace(v)
+			}
+		}
+		if len(m) == 0 {
+			continue
+		}
+		if m["Date"] == "" && m["Action"] == "" {
+			if parent == nil {
+				return nil, errors.New("details without a transaction")
+			}
+			details = append(details, m)
+			continue
+		}
+		flush()
+		// Keep all the columns, as the history page does.
+		parent = make(map[string]string)
+		for _, k := range header {
+			if k != "" {
+				parent[k] = ""
+			}
+		}
+		for k, v := range m {
+			parent[k] = v
+		}
+	}
+	flush()
+	return entries, nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"sort"
+)
+
+// The columns of the history table.
+var historyColumns = []string{
+	"Date",
+	"Action",
+	"Symbol",
+	"Description",
+	"Quantity",
+	"Fees & Commissions",
+	"Disbursement Election",
+	"Amount"}
+
+// Render entries back into a history page shaped like the EAC's,
+// laying out their details as the rules would parse them; this is
+// for constructing test fixtures. Converting the page yields the
+// same entries.
+func writeFixture(w io.Writer, entries []map[string]string, rules []Rule) error {
+	p := func(format string, args ...interface{}) {
+		fmt.Fprintf(w, format, args...)
+	}
+	row := func(e map[string]string) {
+		p("<tr>")
+		for _, k := range historyColumns {
+			p("<td><label>%s</label></td>", html.EscapeString(e[k]))
+		}
+		p("</tr>\n")
+	}
+	details := func(e map[string]string) []string {
+		var keys []string
+		for k := range e {
+			if !derivedKeys[k] && !contains(historyColumns, k) {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		return keys
+	}
+	pane := func(body func()) {
+		p("<tr><td><div><div><table><tbody><tr><td>Details</td></tr></tbody></table><table><tbody>")
+		body()
+		p("</tbody></table></div></div></td></tr>\n")
+	}
+	table := func(group []map[string]string) {
+		seen := make(map[string]bool)
+		var keys []string
+		for _, e := range group {
+			for _, k := range details(e) {
+				if !seen[k] {
+					seen[k] = true
+					keys = append(keys, k)
+				}
+			}
+		}
+		sort.Strings(keys)
+		pane(func() {
+			p("<tr>")
+			for _, k := range keys {
+				p("<td><b>%s</b></td>", html.EscapeString(k))
+			}
+			p("</tr>")
+			for _, e := range group {
+				p("<tr>")
+				for _, k := range keys {
+					p("<td>%s</td>", html.EscapeString(e[k]))
+				}
+				p("</tr>")
+			}
+		})
+	}
+
+	p("<html><body>\n")
+	if len(entries) > 0 && entries[0]["Account Name"] != "" {
+		p("<div>Account: %s</div>\n", html.EscapeString(entries[0]["Account Name"]))
+	}
+	p("<a name=\"History\"><table><tbody><tr><td>Transactions</td></tr><tr><td><table><tbody>\n")
+	header := make(map[string]string)
+	for _, k := range historyColumns {
+		header[k] = k
+	}
+	row(header)
+
+	for i := 0; i < len(entries); i++ {
+		e := entries[i]
+		r := matchRule(rules, e)
+		if r == nil {
+			return fmt.Errorf("no rule for %q", e["Action"])
+		}
+		switch r.Do {
+		case "emit":
+			row(e)
+		case "fields":
+			row(e)
+			pane(func() {
+				for _, k := range details(e) {
+					p("<tr><td><b>%s</b> %s</td></tr>", html.EscapeString(k), html.EscapeString(e[k]))
+				}
+			})
+		case "merge", "split", "auto":
+			// Consecutive entries with the same core keys come
+			// from the same row.
+			group := []map[string]string{e}
+			for ; i+1 < len(entries) && sameCore(e, entries[i+1]); i++ {
+				group = append(group, entries[i+1])
+			}
+			switch {
+			case r.Do == "auto" && len(group) == 1 && len(details(e)) == 0:
+				row(e)
+			case r.Do != "split" && len(group) == 1:
+				row(e)
+				table(group)
+			default:
+				core := make(map[string]string)
+				for _, k := range coreKeys {
+					core[k] = e[k]
+				}
+				row(core)
+				table(group)
+			}
+		default:
+			return fmt.Errorf("%q entries can't be rendered", r.Do)
+		}
+	}
+
+	p("</tbody></table></td></tr></tbody></table></a>\n</body></html>\n")
+	return nil
+}
+
+func sameCore(a, b map[string]string) bool {
+	for _, k := range coreKeys {
+		if a[k] != b[k] {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(list []string, s string) bool {
+	for _, t := range list {
+		if t == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Render the entries as an EAC history page, for use as a test
+// fixture.
+func fixtureCommand(args []string) error {
+	rules, err := loadRules(*rulesFlag)
+	if err != nil {
+		return err
+	}
+	entries, err := load(args)
+	if err != nil {
+		return err
+	}
+	return writeFixture(os.Stdout, entries, rules)
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// The columns of Forms 3921 and 3922 data, by their box labels or
+// shorter names, and the keys they are given as. Form 3922's
+// "Exercise price paid per share" is its box 5, and its exercise
+// date FMV box 4; the Grant FMV is 3922's box 3.
+var formColumns = map[string]string{
+	"form":                          "Form",
+	"grant date":                    "Grant Date",
+	"date of grant":                 "Grant Date",
+	"date option granted":           "Grant Date",
+	"exercise date":                 "Exercise Date",
+	"date of exercise":              "Exercise Date",
+	"date option exercised":         "Exercise Date",
+	"exercise price":                "Exercise Price",
+	"exercise price per share":      "Exercise Price",
+	"exercise price paid per share": "Exercise Price",
+	"fmv":                           "FMV",
+	"fair market value":             "FMV",
+	"fair market value of share on exercise date": "FMV",
+	"fmv per share on exercise date":              "FMV",
+	"grant fmv":                                   "Grant FMV",
+	"fmv per share on grant date":                 "Grant FMV",
+	"shares":                                      "Shares",
+	"number of shares transferred":                "Shares",
+}
+
+func readForms(file string) ([]map[string]string, error) {
+	f, err := openInput(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("%s: no header", file)
+	}
+
+	header := make([]string, len(rows[0]))
+	for i, h := range rows[0] {
+		header[i] = formColumns[strings.ToLower(strings.TrimSpace(h))]
+	}
+	var forms []map[string]string
+	for i, row := range rows[1:] {
+		m := make(map[string]string)
+		for j, v := range row {
+			if j < len(header) && header[j] != "" {
+				m[header[j]] = strings.TrimSpace(v)
+			}
+		}
+		switch m["Form"] = strings.TrimPrefix(m["Form"], "Form "); m["Form"] {
+		case "3921", "3922":
+		default:
+			return nil, fmt.Errorf("%s:%d: bad form %q", file, i+2, m["Form"])
+		}
+		forms = append(forms, m)
+	}
+	return forms, nil
+}
+
+// The keys of the entries corresponding to each form's fields:
+// Exer and Hold entries for Form 3921 (ISO exercises), and ESPP
+// purchases for 3922.
+var formKeys = map[string]map[string][]string{
+	"3921": {
+		"Exercise Date":  {"Date"},
+		"Grant Date":     {"Award Date"},
+		"Exercise Price": {"Award Price"},
+		"FMV":            {"Fair Market Value"},
+		"Shares":         {"Shares", "Quantity"},
+	},
+	"3922": {
+		"Exercise Date":  {"Purchase Date"},
+		"Grant Date":     {"Subscription Date"},
+		"Exercise Price": {"Purchase Price"},
+		"FMV":            {"Purchase FMV"},
+		"Grant FMV":      {"Subscription FMV"},
+		"Shares":         {"Shares", "Quantity"},
+	},
+}
+
+// Cross-reference Forms 3921 and 3922, as given in CSV, against
+// the ISO exercises and ESPP purchases among the entries: each
+// form is matched with the entries of its exercise (or purchase)
+// date, and reported with any fields that disagree. Share counts
+// are totalled across the matching entries.
+func formsCommand(args []string) error {
+	if len(args) < 1 {
+		return errors.New("usage: eac2json forms forms.csv [file...]")
+	}
+	q, err := parseQuery(*queryFlag)
+	if err != nil {
+		return err
+	}
+	forms, err := readForms(args[0])
+	if err != nil {
+		return err
+	}
+	entries, err := load(args[1:])
+	if err != nil {
+		return err
+	}
+
+	// ESPP purchases sold the same day ("Sale") are reported on
+	// 3922s as well as those deposited.
+	matches := func(form, e map[string]string) bool {
+		switch form["Form"] {
+		case "3921":
+			if e["Action"] != "Exer and Hold" || e["Type"] != "" && e["Type"] != "ISO" {
+				return false
+			}
+		case "3922":
+			if e["Purchase Date"] == "" {
+				return false
+			}
+		}
+		keys := formKeys[form["Form"]]
+		if !sameDate(form["Exercise Date"], e[keys["Exercise Date"][0]]) {
+			return false
+		}
+		g := form["Grant Date"]
+		return g == "" || sameDate(g, e[keys["Grant Date"][0]])
+	}
+
+	var records []map[string]string
+	for _, form := range forms {
+		r := map[string]string{
+			"Form":          form["Form"],
+			"Exercise Date": form["Exercise Date"],
+			"Grant Date":    form["Grant Date"],
+		}
+		var matched []map[string]string
+		for _, e := range entries {
+			if matches(form, e) {
+				matched = append(matched, e)
+			}
+		}
+		if len(matched) == 0 {
+			r["Status"] = "missing"
+			records = append(records, r)
+			continue
+		}
+
+		var mismatches []string
+		keys := formKeys[form["Form"]]
+		for _, k := range []string{"Exercise Price", "FMV", "Grant FMV", "Shares"} {
+			want, ok := amount(form, k)
+			if !ok || keys[k] == nil {
+				continue
+			}
+			var got Decimal
+			for _, e := range matched {
+				v, ok := first(e, keys[k])
+				if !ok {
+					continue
+				}
+				if k != "Shares" {
+					got = v
+					break
+				}
+				got = got.Add(v)
+			}
+			if got.Cmp(want) != 0 {
+				mismatches = append(mismatches, fmt.Sprintf("%s: form %s, entries %s", k, want, got))
+			}
+		}
+		r["Entries"] = fmt.Sprint(len(matched))
+		r["Status"] = "ok"
+		if len(mismatches) > 0 {
+			r["Status"] = "mismatch"
+			r["Mismatches"] = strings.Join(mismatches, "; ")
+		}
+		records = append(records, r)
+	}
+	return emit(os.Stdout, records, q)
+}
+
+func sameDate(a, b string) bool {
+	s, err1 := parseDate(a)
+	t, err2 := parseDate(b)
+	return err1 == nil && err2 == nil && s.Equal(t)
+}
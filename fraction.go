@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Give the cash paid in lieu of fractional shares, which lapses
+// bury in their details under various labels, as "Cash in Lieu",
+// and the fraction, if given, as "Fractional Shares".
+func cashInLieu(entries []map[string]string) {
+	for _, e := range entries {
+		for k, v := range e {
+			switch l := strings.ToLower(k); {
+			case k == "Cash in Lieu" || k == "Fractional Shares" || v == "":
+			case strings.Contains(l, "in lieu") || strings.Contains(l, "fractional cash"):
+				e["Cash in Lieu"] = v
+			case strings.Contains(l, "fractional share"):
+				e["Fractional Shares"] = v
+			}
+		}
+	}
+}
+
+// With -cash-in-lieu sale, the fractional share paid out in cash is
+// acquired with the rest, and sold at once for the cash, so that its
+// basis is accounted for. The fraction is taken from the entry, or
+// else from the cash and the fair market value.
+func inLieuSale(e map[string]string) (map[string]string, error) {
+	if *inLieuFlag != "sale" {
+		return nil, nil
+	}
+	cash, ok := amount(e, "Cash in Lieu")
+	if !ok || cash.Sign() == 0 {
+		return nil, nil
+	}
+	cost, ok := first(e, costKeys)
+	if !ok || cost.Sign() == 0 {
+		return nil, fmt.Errorf("%s %s: cash in lieu, but no fair market value", e["Date"], e["Action"])
+	}
+	fraction, ok := amount(e, "Fractional Shares")
+	if !ok || fraction.Sign() == 0 {
+		fraction = cash.Quo(cost)
+	}
+	return map[string]string{
+		"Action":            "Cash in Lieu",
+		"Date":              e["Date"],
+		"Symbol":            e["Symbol"],
+		"Source":            e["Source"],
+		"Seq":               e["Seq"],
+		"Shares":            fraction.String(),
+		"Fair Market Value": cost.String(),
+		"Sale Price":        cash.Quo(fraction).String(),
+	}, nil
+}
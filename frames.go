@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Some saved pages hold the EAC's content in an iframe, which is
+// saved alongside the page, or inlined in MHTML. A frameFunc finds
+// the document of an iframe by its src.
+type frameFunc func(src string) (io.Reader, error)
+
+var errNoHistory = errors.New("no history")
+
+// Frames saved alongside a page in dir, as by "save complete page".
+// Only files within dir are read, as the page may be anyone's, as
+// when uploaded to serve.
+func fileFrames(dir string) frameFunc {
+	if dir == "" {
+		return nil
+	}
+	return func(src string) (io.Reader, error) {
+		u, err := url.Parse(src)
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme != "" || u.Host != "" || path.IsAbs(u.Path) {
+			return nil, fmt.Errorf("not saved with the page")
+		}
+		file := filepath.Join(dir, filepath.FromSlash(u.Path))
+		rel, err := filepath.Rel(dir, file)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return nil, fmt.Errorf("not saved with the page")
+		}
+		b, err := readInput(file)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.NewReader(b), nil
+	}
+}
+
+// Frames inlined in MHTML, by their Content-Location or Content-ID,
+// relative to that of the page.
+func mhtmlFrames(base string, parts map[string][]byte) frameFunc {
+	return func(src string) (io.Reader, error) {
+		if b, ok := parts[src]; ok {
+			return bytes.NewReader(b), nil
+		}
+		if u, err := url.Parse(base); err == nil {
+			if ref, err := url.Parse(src); err == nil {
+				if b, ok := parts[u.ResolveReference(ref).String()]; ok {
+					return bytes.NewReader(b), nil
+				}
+			}
+		}
+		return nil, fmt.Errorf("not in MHTML")
+	}
+}
+
+// Parse the documents of the page's iframes, returning the entries
+// of the first that has any. Frames in frames are followed, to a
+// point.
+func parseFrames(doc *html.Node, rules []Rule, frames frameFunc, depth int) ([]map[string]string, error) {
+	if depth > 3 {
+		return nil, errNoHistory
+	}
+	for _, f := range findFrames(doc) {
+		var r io.Reader
+		src := attr(f, "src")
+		switch {
+		case hasAttr(f, "srcdoc"):
+			r = strings.NewReader(attr(f, "srcdoc"))
+		case src == "" || frames == nil:
+			continue
+		default:
+			var err error
+			if r, err = frames(src); err != nil {
+				log.Printf("frame %s: %s", src, err)
+				continue
+			}
+		}
+
+		entries, err := parseDepth(r, rules, frames, depth+1)
+		if err == errNoHistory {
+			continue
+		}
+		if err != nil && src != "" {
+			err = fmt.Errorf("frame %s: %s", src, err)
+		}
+		return entries, err
+	}
+	return nil, errNoHistory
+}
+
+func findFrames(n *html.Node) []*html.Node {
+	var frames []*html.Node
+	if n.Type == html.ElementNode && (n.Data == "iframe" || n.Data == "frame") {
+		frames = append(frames, n)
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		frames = append(frames, findFrames(c)...)
+	}
+	return frames
+}
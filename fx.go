@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// FX rates, in units of the home currency per US dollar, read
+// from a CSV file of date,rate rows. The date may be a day (e.g.
+// daily ECB reference rates, inverted) or a year (e.g. the IRS
+// yearly average rates). Daily rates are preferred.
+type fxRates struct {
+	daily  map[string]Decimal
+	yearly map[string]Decimal
+}
+
+func readFX(file string) (*fxRates, error) {
+	f, err := openInput(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+
+	fx := &fxRates{make(map[string]Decimal), make(map[string]Decimal)}
+	for i, row := range rows {
+		if len(row) < 2 {
+			return nil, fmt.Errorf("%s:%d: want date,rate", file, i+1)
+		}
+		rate, err := parseDecimal(row[1])
+		if err != nil {
+			if i == 0 {
+				continue // header
+			}
+			return nil, fmt.Errorf("%s:%d: %s", file, i+1, err)
+		}
+		date := strings.TrimSpace(row[0])
+		if len(date) == 4 {
+			fx.yearly[date] = rate
+			continue
+		}
+		t, err := parseDate(date)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", file, i+1, err)
+		}
+		fx.daily[t.Format("2006-01-02")] = rate
+	}
+	return fx, nil
+}
+
+// Find the rate for a day: that day's, or the last one published
+// in the week before (rates aren't published on weekends and
+// holidays), or else the year's average.
+func (fx *fxRates) Rate(t time.Time) (rate Decimal, source string, ok bool) {
+	for i := 0; i < 7; i++ {
+		day := t.AddDate(0, 0, -i).Format("2006-01-02")
+		if rate, ok := fx.daily[day]; ok {
+			return rate, "daily " + day, true
+		}
+	}
+	year := t.Format("2006")
+	if rate, ok := fx.yearly[year]; ok {
+		return rate, "yearly " + year, true
+	}
+	return Decimal{}, "", false
+}
+
+// Convert the dollar amounts in each entry to the home currency,
+// adding them as "Key (CUR)", along with the rate and its source.
+// Entries without a date are left as they are.
+func convertFX(entries []map[string]string, fx *fxRates, currency string) error {
+	for _, e := range entries {
+		if e["Date"] == "" {
+			continue
+		}
+		date, err := parseDate(e["Date"])
+		if err != nil {
+			return err
+		}
+		rate, source, ok := fx.Rate(date)
+		if !ok {
+			return fmt.Errorf("%s: no %s rate", e["Date"], currency)
+		}
+
+		for k, v := range e {
+			if !strings.Contains(v, "$") {
+				continue
+			}
+			if d, err := parseDecimal(v); err == nil {
+				e[k+" ("+currency+")"] = d.Mul(rate).Fixed(2)
+			}
+		}
+		e["FX Rate"] = rate.String()
+		e["FX Source"] = source
+	}
+	return nil
+}
+
+// Whether a key of an entry is one added by convertFX, as when its
+// output is read back in.
+func fxKey(e map[string]string, k string) bool {
+	i := strings.LastIndex(k, " (")
+	if e["FX Rate"] == "" || i < 0 || !strings.HasSuffix(k, ")") {
+		return false
+	}
+	_, ok := e[k[:i]]
+	return ok
+}
@@ -0,0 +1,55 @@
+package main
+
+import "os"
+
+// The columns of the gains report.
+var gainColumns = []string{"Term", "Description", "Date Acquired", "Date Sold", "Proceeds", "Cost Basis", "Code", "Adjustment", "Gain or Loss"}
+
+// Report the gain or loss realized in -year, a row per lot sold, as
+// in Form 8949, followed by the short- and long-term totals and
+// their sum, as -format.
+func gainsCommand(args []string) error {
+	q, err := parseQuery(*queryFlag)
+	if err != nil {
+		return err
+	}
+	b, err := book(args)
+	if err != nil {
+		return err
+	}
+	rows := form8949(b.Year())
+	lines, err := schedule(rows)
+	if err != nil {
+		return err
+	}
+
+	records := gainRecords(rows)
+	var total scheduleD
+	for i, d := range lines {
+		term := "Short-term"
+		if i == 1 {
+			term = "Long-term"
+		}
+		records = append(records, gainTotal(term, d))
+		total.proceeds = total.proceeds.Add(d.proceeds)
+		total.basis = total.basis.Add(d.basis)
+		total.adjustment = total.adjustment.Add(d.adjustment)
+		total.gain = total.gain.Add(d.gain)
+	}
+	records = append(records, gainTotal("", &total))
+	return report(os.Stdout, gainColumns, records, q)
+}
+
+func gainTotal(term string, d *scheduleD) map[string]string {
+	r := map[string]string{
+		"Description":  "Total",
+		"Proceeds":     d.proceeds.Fixed(2),
+		"Cost Basis":   d.basis.Fixed(2),
+		"Adjustment":   d.adjustment.Fixed(2),
+		"Gain or Loss": d.gain.Fixed(2),
+	}
+	if term != "" {
+		r["Term"] = term
+	}
+	return r
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// A mapping describes a broker's CSV export for -source generic,
+// as a plan (see plans) does those we know. In JSON:
+//
+//	{
+//		"name": "mybroker",
+//		"sign": ["Trade Date", "Activity"],
+//		"columns": {"Trade Date": "Date", "Activity": "Action", "Qty": "Shares"},
+//		"actions": {"BOUGHT": "Buy", "SOLD": "Sell", "ESPP": "Buy"},
+//		"types": {"ESPP": "ESPP"}
+//	}
+//
+// or the same in YAML, as far as flat maps and lists go:
+//
+//	name: mybroker
+//	sign: [Trade Date, Activity]
+//	columns:
+//	  Trade Date: Date
+//	  Activity: Action
+//
+// Columns are mapped to our keys; the one mapped to "Action" holds
+// the transaction types, which are mapped to actions by "actions",
+// and to award types by "types". The header is the first row with
+// all the columns in "sign", or else the first row.
+type mapping struct {
+	Name    string
+	Sign    []string
+	Columns map[string]string
+	Actions map[string]string
+	Types   map[string]string
+}
+
+func readMapping(file string) (*plan, error) {
+	var m mapping
+	if strings.HasSuffix(strings.ToLower(file), ".json") {
+		f, err := openInput(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if err := json.NewDecoder(f).Decode(&m); err != nil {
+			return nil, fmt.Errorf("%s: %s", file, err)
+		}
+	} else if err := readYAMLMapping(file, &m); err != nil {
+		return nil, err
+	}
+	if len(m.Columns) == 0 {
+		return nil, fmt.Errorf("%s: no columns", file)
+	}
+
+	p := &plan{
+		name:    m.Name,
+		columns: make(map[string]string),
+		actions: make(map[string]planAction),
+	}
+	if p.name == "" {
+		p.name = "generic"
+	}
+	for _, s := range m.Sign {
+		p.sign = append(p.sign, strings.ToLower(strings.TrimSpace(s)))
+	}
+	for col, k := range m.Columns {
+		if k == "Action" {
+			k = "Transaction Type"
+		}
+		p.columns[strings.ToLower(strings.TrimSpace(col))] = k
+	}
+	for typ, a := range m.Actions {
+		p.actions[strings.ToLower(typ)] = planAction{action: a}
+	}
+	for typ, t := range m.Types {
+		a, ok := p.actions[strings.ToLower(typ)]
+		if !ok {
+			a.action = typ
+		}
+		a.typ = t
+		p.actions[strings.ToLower(typ)] = a
+	}
+	return p, nil
+}
+
+// Read the YAML form of a mapping: top-level scalars and flow
+// lists, and maps and lists indented under their keys. Comments
+// and quotes are dropped.
+func readYAMLMapping(file string, m *mapping) error {
+	f, err := openInput(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	unquote := func(s string) string {
+		s = strings.TrimSpace(s)
+		if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+			s = s[1 : len(s)-1]
+		}
+		return s
+	}
+	list := func(s string) []string {
+		var l []string
+		for _, v := range strings.Split(strings.Trim(s, "[]"), ",") {
+			if v = unquote(v); v != "" {
+				l = append(l, v)
+			}
+		}
+		return l
+	}
+	maps := map[string]*map[string]string{
+		"columns": &m.Columns,
+		"actions": &m.Actions,
+		"types":   &m.Types,
+	}
+
+	var (
+		section string
+		n       int
+		s       = bufio.NewScanner(f)
+	)
+	for s.Scan() {
+		n++
+		line := s.Text()
+		if i := strings.Index(line, " #"); i >= 0 {
+			line = line[:i]
+		}
+		if strings.HasPrefix(strings.TrimSpace(line), "#") || strings.TrimSpace(line) == "" {
+			continue
+		}
+		indented := line[0] == ' ' || line[0] == '\t'
+		line = strings.TrimSpace(line)
+
+		if indented && strings.HasPrefix(line, "- ") {
+			if section != "sign" {
+				return fmt.Errorf("%s:%d: unexpected list item", file, n)
+			}
+			m.Sign = append(m.Sign, unquote(line[2:]))
+			continue
+		}
+		// Keys may contain colons if quoted.
+		k, v, ok := "", "", false
+		if line[0] == '"' || line[0] == '\'' {
+			if i := strings.IndexByte(line[1:], line[0]); i >= 0 && strings.HasPrefix(line[i+2:], ":") {
+				k, v, ok = line[1:i+1], line[i+3:], true
+			}
+		} else {
+			k, v, ok = strings.Cut(line, ":")
+		}
+		if !ok {
+			return fmt.Errorf("%s:%d: expected key: value", file, n)
+		}
+		k, v = strings.TrimSpace(k), unquote(v)
+
+		if indented {
+			mp, ok := maps[section]
+			if !ok {
+				return fmt.Errorf("%s:%d: unexpected %q", file, n, k)
+			}
+			if *mp == nil {
+				*mp = make(map[string]string)
+			}
+			(*mp)[k] = v
+			continue
+		}
+		section = k
+		switch k {
+		case "name":
+			m.Name = v
+		case "sign":
+			m.Sign = list(v)
+		case "columns", "actions", "types":
+		default:
+			return fmt.Errorf("%s:%d: unknown key %q", file, n, k)
+		}
+	}
+	return s.Err()
+}
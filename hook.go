@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Detail is the "more details" pane following a row. Since
+// layouts vary, it is parsed both as a table (a header row
+// followed by data rows) and as key/value pairs. Any further
+// tables are given in Extra (see extra).
+type Detail struct {
+	Table  []map[string]string `json:"table"`
+	Fields map[string]string   `json:"fields"`
+	Extra  map[string]string   `json:"extra"`
+}
+
+// A Hook handles rows with a particular action, returning the
+// entries they produce. The detail is nil if the row has no
+// "more details" pane.
+type Hook func(row map[string]string, d *Detail) ([]map[string]string, error)
+
+var hooks = make(map[string]Hook)
+
+// Register a hook for an action. Hooks take precedence over the
+// built-in handling, so they may also be used to override it.
+func RegisterHook(action string, h Hook) {
+	hooks[action] = h
+}
+
+// Report whether the row following n is a "more details" pane
+// rather than a regular data row.
+func isDetail(n *Node) bool {
+	n.Push()
+	defer n.Pop()
+
+	n.Sibling("tr")
+	n.Child("td")
+	n.Child("div")
+	return n.Ok()
+}
+
+// Parse the detail pane following n, if any, advancing n past it.
+func detail(n *Node) (*Detail, error) {
+	if !isDetail(n) {
+		return nil, nil
+	}
+
+	n.Sibling("tr")
+	d := new(Detail)
+	var err error
+	if d.Table, err = more(n, ignore); err != nil {
+		return nil, err
+	}
+	if d.Fields, err = more1(n, ignore); err != nil {
+		return nil, err
+	}
+	d.Extra = extra(n, ignore)
+	return d, nil
+}
+
+// Report whether the detail pane is laid out as key/value pairs
+// rather than as a table: parsed as pairs, a table has no values.
+func (d *Detail) keyed() bool {
+	for _, v := range d.Fields {
+		if v != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// An exec hook runs a command for each row. The command is given
+// a JSON object {"row": ..., "detail": ...} on its standard input
+// and must write a JSON array of entries to its standard output.
+func execHook(command string) Hook {
+	return func(row map[string]string, d *Detail) ([]map[string]string, error) {
+		in, err := json.Marshal(struct {
+			Row    map[string]string `json:"row"`
+			Detail *Detail           `json:"detail"`
+		}{row, d})
+		if err != nil {
+			return nil, err
+		}
+
+		var out bytes.Buffer
+		cmd := exec.Command("sh", "-c", command)
+		cmd.Stdin = bytes.NewReader(in)
+		cmd.Stdout = &out
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return nil, fmt.Errorf("hook %q: %s", command, err)
+		}
+
+		var entries []map[string]string
+		if err := json.Unmarshal(out.Bytes(), &entries); err != nil {
+			return nil, fmt.Errorf("hook %q: %s", command, err)
+		}
+		return entries, nil
+	}
+}
+
+// hookFlag registers exec hooks given as action=command.
+type hookFlag struct{}
+
+func (hookFlag) String() string { return "" }
+
+func (hookFlag) Set(s string) error {
+	i := strings.Index(s, "=")
+	if i <= 0 {
+		return errors.New("hook must be action=command")
+	}
+	RegisterHook(s[:i], execHook(s[i+1:]))
+	return nil
+}
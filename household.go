@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// A household manifest lists the accounts of a household: whose
+// they are, and the files holding their histories.
+type member struct {
+	Account string
+	Owner   string
+	Files   []string
+}
+
+func loadManifest(file string) ([]member, error) {
+	f, err := openInput(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var m []member
+	if err := json.NewDecoder(f).Decode(&m); err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	return m, nil
+}
+
+// A summary of an account, or of the household as a whole.
+type summary struct {
+	owner, account            string
+	short, long, disallowed   Decimal
+	withheld, vested          Decimal
+	proceeds                  Decimal
+	entries, sales, washSales int
+}
+
+// Count an entry's taxes withheld (see withholdings), and shares
+// vested.
+func (s *summary) entry(e map[string]string, taxes map[string]Decimal) {
+	for _, v := range taxes {
+		s.withheld = s.withheld.Add(v)
+	}
+	if lapseActions[e["Action"]] {
+		if n, ok := amount(e, "Quantity"); ok {
+			s.vested = s.vested.Add(n)
+		}
+	}
+}
+
+// Count a sale's proceeds and gain, as reported, i.e. with any
+// disallowed loss added back.
+func (s *summary) sale(sale *Sale) {
+	s.sales++
+	s.proceeds = s.proceeds.Add(sale.Proceeds)
+	gain := sale.Gain().Add(sale.Disallowed)
+	if sale.Long() {
+		s.long = s.long.Add(gain)
+	} else {
+		s.short = s.short.Add(gain)
+	}
+	if sale.Disallowed.Sign() != 0 {
+		s.washSales++
+		s.disallowed = s.disallowed.Add(sale.Disallowed)
+	}
+}
+
+func (s *summary) record() map[string]string {
+	return map[string]string{
+		"Record":          "summary",
+		"Owner":           s.owner,
+		"Account Name":    s.account,
+		"Entries":         fmt.Sprint(s.entries),
+		"Sales":           fmt.Sprint(s.sales),
+		"Wash Sales":      fmt.Sprint(s.washSales),
+		"Short-Term Gain": s.short.Fixed(2),
+		"Long-Term Gain":  s.long.Fixed(2),
+		"Disallowed":      s.disallowed.Fixed(2),
+		"Withheld":        s.withheld.Fixed(2),
+		"Vested Shares":   s.vested.String(),
+		"Proceeds":        s.proceeds.Fixed(2),
+	}
+}
+
+// Report on a household: the accounts named by the manifest are
+// run through a single book, so that wash sales are detected across
+// all of them, and summarized per account and in total. Gains are
+// as reported, i.e. with disallowed losses added back. Entries are
+// tagged with their "Account Name" and "Owner"; -year selects the
+// sales and withholding reported on.
+func householdCommand(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: eac2json household manifest.json")
+	}
+	q, err := parseQuery(*queryFlag)
+	if err != nil {
+		return err
+	}
+	members, err := loadManifest(args[0])
+	if err != nil {
+		return err
+	}
+
+	var (
+		all      []map[string]string
+		sums     []*summary
+		bySource = make(map[string]*summary)
+	)
+	for _, m := range members {
+		if len(m.Files) == 0 {
+			return fmt.Errorf("account %q has no files", m.Account)
+		}
+		entries, err := load(m.Files)
+		if err != nil {
+			return err
+		}
+		// Sales are traced back to their account by source.
+		if len(m.Files) == 1 {
+			tag(entries, "Source", m.Files[0])
+		}
+		// The manifest's account wins over the pages', as -account
+		// does.
+		if m.Account != "" {
+			for _, e := range entries {
+				e["Account Name"] = m.Account
+			}
+		}
+		tag(entries, "Owner", m.Owner)
+
+		s := &summary{owner: m.Owner, account: m.Account, entries: len(entries)}
+		taxes := withholdings(entries)
+		for i, e := range entries {
+			bySource[e["Source"]] = s
+			date, err := parseDate(e["Date"])
+			if err != nil || *yearFlag != 0 && date.Year() != *yearFlag {
+				continue
+			}
+			s.entry(e, taxes[i])
+		}
+		sums = append(sums, s)
+		all = append(all, entries...)
+	}
+
+	c, err := loadWashConfig(*washConfigFlag)
+	if err != nil {
+		return err
+	}
+	b := &Book{Household: true}
+	c.Configure(b)
+	if err := b.Run(all); err != nil {
+		return err
+	}
+
+	total := &summary{owner: "Household", entries: len(all)}
+	for _, sale := range b.Year() {
+		for _, s := range []*summary{bySource[sale.Source], total} {
+			if s != nil {
+				s.sale(sale)
+			}
+		}
+	}
+	for _, s := range sums {
+		total.withheld = total.withheld.Add(s.withheld)
+		total.vested = total.vested.Add(s.vested)
+	}
+
+	sort.SliceStable(sums, func(i, j int) bool {
+		return sums[i].owner < sums[j].owner
+	})
+	var records []map[string]string
+	for _, s := range append(sums, total) {
+		records = append(records, s.record())
+	}
+
+	name := func(source string) (owner, account string) {
+		if s := bySource[source]; s != nil {
+			return s.owner, s.account
+		}
+		return "", ""
+	}
+	for _, w := range b.Washes {
+		if *yearFlag != 0 && w.Sale.Sold.Year() != *yearFlag {
+			continue
+		}
+		owner, account := name(w.Sale.Source)
+		rowner, raccount := name(w.Replacement.Source)
+		records = append(records, map[string]string{
+			"Record":                   "wash",
+			"Date":                     formatDate(w.Sale.Sold),
+			"Symbol":                   w.Sale.Symbol,
+			"Owner":                    owner,
+			"Account Name":             account,
+			"Shares":                   w.Shares.String(),
+			"Disallowed":               w.Disallowed.Fixed(2),
+			"Replacement Date":         formatDate(w.Replacement.Acquired),
+			"Replacement Owner":        rowner,
+			"Replacement Account Name": raccount,
+		})
+	}
+	return emit(os.Stdout, records, q)
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The interchange format is the events model (see modelEvent),
+// which other tools may read and write without this program. It is
+// versioned apart from the entries, as eventsSchema; output written
+// with -model events and -envelope records it as the envelope's
+// "schema". The schema command writes its definition as JSON
+// Schema, and the validate command checks files against it.
+//
+// Version 1 has the keys of eventKeys, of which "Type" and "Date"
+// are required. Changes that add optional keys or event types keep
+// the version; others bump it.
+const eventsSchema = "eac2json-events/1"
+
+// The event types, as the "Type" of events.
+var eventTypeNames = []string{"Vest", "TaxSale", "Sale", "Exercise", "Transfer", "Dividend", "Other"}
+
+// The keys of events, with the kinds of their values: "date" (as
+// written, or an RFC 3339 timestamp with -timezone; empty if not
+// known), "decimal" (a share count or dollar amount, as in the
+// history page, e.g. "$1,234.56", negative in parentheses), or
+// "string".
+var eventKeys = []struct {
+	key, kind, doc string
+}{
+	{"Type", "type", "what the event is"},
+	{"Action", "string", "the action as recorded, for Other events"},
+	{"Date", "date", "when the event took place"},
+	{"Symbol", "string", "the security"},
+	{"Shares", "decimal", "the number of shares"},
+	{"Price", "decimal", "the price per share: the FMV of vests, the sale price of sales, the strike price of exercises, or the purchase price of reinvestments and transfers"},
+	{"Fair Market Value", "decimal", "the FMV per share of exercised shares"},
+	{"Amount", "decimal", "the cash amount"},
+	{"Fees", "decimal", "fees and commissions"},
+	{"Award ID", "string", "the grant"},
+	{"Account Name", "string", "the account"},
+	{"Entry", "string", "the ID of the entry the event models"},
+}
+
+var (
+	datePattern    = `^$|^\d{2}/\d{2}/\d{4}$|^\d{4}-\d{2}-\d{2}(T\d{2}:\d{2}:\d{2}(Z|[+-]\d{2}:\d{2}))?$`
+	decimalPattern = `^\(?-?\$?\d[\d,]*(\.\d+)?\)?$`
+)
+
+// The JSON Schema of the interchange format: events, either as an
+// array or in an envelope.
+func interchangeSchema() map[string]interface{} {
+	props := make(map[string]interface{})
+	for _, k := range eventKeys {
+		p := map[string]interface{}{"type": "string", "description": k.doc}
+		switch k.kind {
+		case "type":
+			p["enum"] = eventTypeNames
+		case "date":
+			p["pattern"] = datePattern
+		case "decimal":
+			p["pattern"] = decimalPattern
+		}
+		props[k.key] = p
+	}
+	events := map[string]interface{}{
+		"type":  "array",
+		"items": map[string]interface{}{"$ref": "#/$defs/event"},
+	}
+	return map[string]interface{}{
+		"$schema":     "https://json-schema.org/draft/2020-12/schema",
+		"$id":         eventsSchema,
+		"title":       "eac2json equity compensation events",
+		"description": "Events, as written by eac2json -model events, bare or with -envelope.",
+		"oneOf": []interface{}{
+			events,
+			map[string]interface{}{
+				"type":     "object",
+				"required": []string{"schema", "entries"},
+				"properties": map[string]interface{}{
+					"schema":         map[string]interface{}{"const": eventsSchema},
+					"schema_version": map[string]interface{}{"type": "integer"},
+					"entries":        events,
+				},
+			},
+		},
+		"$defs": map[string]interface{}{
+			"event": map[string]interface{}{
+				"type":                 "object",
+				"required":             []string{"Type", "Date"},
+				"additionalProperties": false,
+				"properties":           props,
+			},
+		},
+	}
+}
+
+// Write the JSON Schema of the interchange format.
+func schemaCommand(args []string) error {
+	if len(args) != 0 {
+		return errors.New("usage: eac2json schema")
+	}
+	b, err := json.MarshalIndent(interchangeSchema(), "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Printf("%s\n", b)
+	return err
+}
+
+// Validate files of events against the interchange format,
+// printing the problems found.
+func validateCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: eac2json validate file...")
+	}
+	n := 0
+	for _, file := range args {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		for _, err := range validateEvents(b) {
+			log.Printf("%s: %s", file, err)
+			n++
+		}
+	}
+	if n > 0 {
+		return fmt.Errorf("%d problems", n)
+	}
+	return nil
+}
+
+func validateEvents(b []byte) []error {
+	var events []map[string]interface{}
+	if err := json.Unmarshal(b, &events); err != nil {
+		var env struct {
+			Schema  string                   `json:"schema"`
+			Entries []map[string]interface{} `json:"entries"`
+		}
+		if err := json.Unmarshal(b, &env); err != nil {
+			return []error{err}
+		}
+		if env.Schema != eventsSchema {
+			return []error{fmt.Errorf("schema %q, not %q", env.Schema, eventsSchema)}
+		}
+		events = env.Entries
+	}
+
+	var (
+		kinds    = make(map[string]string)
+		types    = make(map[string]bool)
+		date     = regexp.MustCompile(datePattern)
+		decimal  = regexp.MustCompile(decimalPattern)
+		problems []error
+	)
+	for _, k := range eventKeys {
+		kinds[k.key] = k.kind
+	}
+	for _, t := range eventTypeNames {
+		types[t] = true
+	}
+	for i, ev := range events {
+		bad := func(format string, args ...interface{}) {
+			problems = append(problems, fmt.Errorf("event %d: %s", i+1, fmt.Sprintf(format, args...)))
+		}
+		for _, k := range []string{"Type", "Date"} {
+			if _, ok := ev[k]; !ok {
+				bad("no %s", k)
+			}
+		}
+		keys := make([]string, 0, len(ev))
+		for k := range ev {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			kind, ok := kinds[k]
+			if !ok {
+				bad("unknown key %q", k)
+				continue
+			}
+			v, ok := ev[k].(string)
+			if !ok {
+				bad("%s is not a string", k)
+				continue
+			}
+			switch kind {
+			case "type":
+				if !types[v] {
+					bad("unknown type %q", v)
+				}
+			case "date":
+				if !date.MatchString(v) || !validDate(v) {
+					bad("bad %s %q", k, v)
+				}
+			case "decimal":
+				if !decimal.MatchString(v) {
+					bad("bad %s %q", k, v)
+				}
+			}
+		}
+		if ev["Type"] == "Other" && ev["Action"] == nil {
+			bad("Other event without an Action")
+		}
+	}
+	return problems
+}
+
+func validDate(s string) bool {
+	if s == "" || strings.Contains(s, "T") {
+		_, err := time.Parse(time.RFC3339, s)
+		return s == "" || err == nil
+	}
+	_, err := parseDate(s)
+	return err == nil
+}
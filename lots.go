@@ -0,0 +1,604 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Keys holding share counts and per-share prices, in order of
+// preference. The basis of shares acquired through vesting or
+// exercise is their fair market value at the time; this does not
+// account for ISO or ESPP compensation adjustments.
+var (
+	sharesKeys = []string{"Shares", "Net Shares Deposited", "Quantity"}
+	costKeys   = []string{"Fair Market Value", "FMV", "Market Price", "Purchase FMV", "Purchase Price", "Price"}
+	saleKeys   = []string{"Sale Price", "Price"}
+)
+
+// Actions that acquire or dispose of shares. Reinvested dividends
+// are held like any other shares. "Buy" and "Sell" are
+// for brokerage histories given as JSON. "Lot" is for lots carried
+// over from an earlier run; see lotEntries. A "Sale" is an exercise
+// (or ESPP purchase) and sale in one, so it carries its own lot, as
+// does "Cash in Lieu" (see inLieuSale).
+var (
+	buyActions  = map[string]bool{"Lapse": true, "Release": true, "Deposit": true, "Exer and Hold": true, "Dividend Reinvestment": true, "Buy": true, "Lot": true}
+	sellActions = map[string]bool{"Forced Quick Sell": true, "Sell to Cover": true, "Quick Sell": true, "Sell": true}
+	saleActions = map[string]bool{"Sale": true, "Cash in Lieu": true}
+)
+
+// Dividend equivalent units become shares when they vest, as given
+// by their "Vest Date"; until then, they're not held.
+var derActions = map[string]bool{"Dividend Equivalent": true, "DER": true}
+
+// Sales from the EAC account: those made to pay taxes, whether
+// forced or configured as sell-to-cover, and voluntary quick sells.
+var (
+	eacSales = map[string]bool{"Forced Quick Sell": true, "Sell to Cover": true, "Quick Sell": true}
+	taxSales = map[string]bool{"Forced Quick Sell": true, "Sell to Cover": true}
+)
+
+// Shares deposited into the EAC account are sold from there;
+// everything else goes to (and is sold from) the brokerage account.
+func account(action string) string {
+	if action == "Deposit" || eacSales[action] {
+		return "EAC"
+	}
+	return "brokerage"
+}
+
+// Parse a date as written by EAC, as ISO 8601, or as an RFC 3339
+// timestamp, as written with -timezone, taking the day in the zone
+// it was written in.
+func parseDate(s string) (time.Time, error) {
+	for _, layout := range []string{"01/02/2006", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC), nil
+	}
+	return time.Time{}, fmt.Errorf("bad date %q", s)
+}
+
+// The date given by -date, or else today's, as a calendar day at
+// UTC midnight, as the dates of entries are parsed.
+func flagDate() (time.Time, error) {
+	if *dateFlag != "" {
+		return parseDate(*dateFlag)
+	}
+	y, m, d := time.Now().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC), nil
+}
+
+// Look up the first of keys present in e.
+func first(e map[string]string, keys []string) (Decimal, bool) {
+	for _, k := range keys {
+		if v, ok := amount(e, k); ok {
+			return v, true
+		}
+	}
+	return Decimal{}, false
+}
+
+// A Lot is a block of shares acquired together.
+type Lot struct {
+	Source   string
+	Owner    string // see owner
+	Account  string
+	Symbol   string
+	Award    string // the award vested or exercised, if known
+	Acquired time.Time
+	Shares   Decimal // acquired
+	Open     Decimal // still held
+	Basis    Decimal // of the open shares
+
+	// The start of the holding period, which differs from
+	// Acquired if the lot replaced shares sold in a wash sale.
+	Since time.Time
+
+	// Whether the lot has served as the replacement in a wash sale;
+	// if so, it is not used again.
+	replaced bool
+
+	// Lots split off this one before it was opened, to be opened
+	// along with it.
+	opened bool
+	next   *Lot
+}
+
+// A Sale is a disposition of shares from a single lot.
+type Sale struct {
+	Source   string
+	Symbol   string
+	Sold     time.Time
+	Acquired time.Time
+	Shares   Decimal
+	Proceeds Decimal
+	Basis    Decimal
+
+	// The part of a loss disallowed by wash sales.
+	Disallowed Decimal
+
+	lot *Lot
+}
+
+func (s *Sale) Gain() Decimal {
+	return s.Proceeds.Sub(s.Basis)
+}
+
+// A Book matches sales against lots, first in, first out, and
+// detects wash sales, across all the entries it is given.
+type Book struct {
+	Lots   []*Lot
+	Sales  []*Sale
+	Washes []*Wash
+
+	// The wash sale window, in days, and substantially identical
+	// symbols; see WashConfig.
+	Window    int
+	identical map[string]string
+
+	// The date of the last entry run.
+	Through time.Time
+
+	// Whether wash sales are detected across owners, as for a
+	// household filing jointly; otherwise only within an owner's
+	// accounts.
+	Household bool
+
+	// Open lots by owner, account, and symbol.
+	open map[string][]*Lot
+}
+
+// Whose an entry's shares are: its "Account Name", or, failing
+// that, its household "Owner", so that lots of different people's
+// histories aren't sold against each other. Entries with neither
+// are all taken to be the same, default owner's, "", whatever file
+// they came from.
+func owner(e map[string]string) string {
+	if a := e["Account Name"]; a != "" {
+		return a
+	}
+	return e["Owner"]
+}
+
+func lotKey(owner, account, symbol string) string {
+	return owner + "\x00" + account + "\x00" + symbol
+}
+
+func (l *Lot) key() string {
+	return lotKey(l.Owner, l.Account, l.Symbol)
+}
+
+type event struct {
+	date time.Time
+	e    map[string]string
+	lot  *Lot
+}
+
+// Run the entries through the book in date order. Entries on the
+// same day are kept in the order given by their "Seq". Linked
+// corrections are applied first, and entries already accounted for
+// by carried lots are dropped; see carryOver.
+func (b *Book) Run(entries []map[string]string) error {
+	b.open = make(map[string][]*Lot)
+	entries, b.Through = carryOver(applyCorrections(entries))
+
+	var events []event
+	for _, e := range entries {
+		action := e["Action"]
+		vested := derActions[action] && e["Vest Date"] != ""
+		if !buyActions[action] && !sellActions[action] && !saleActions[action] && !vested {
+			continue
+		}
+		when := e["Date"]
+		if vested {
+			when = e["Vest Date"]
+		}
+		date, err := parseDate(when)
+		if err != nil {
+			return err
+		}
+		ev := event{date: date, e: e}
+		if buyActions[action] || vested {
+			if ev.lot, err = newLot(e, date); err != nil {
+				return err
+			}
+			// Lots of no shares, as of vests withheld in full,
+			// have nothing to sell.
+			if ev.lot.Open.Sign() == 0 {
+				continue
+			}
+			b.Lots = append(b.Lots, ev.lot)
+		}
+		events = append(events, ev)
+
+		if ev.lot != nil {
+			s, err := inLieuSale(e)
+			if err != nil {
+				return err
+			}
+			if s != nil {
+				events = append(events, event{date: date, e: s})
+			}
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		if !events[i].date.Equal(events[j].date) {
+			return events[i].date.Before(events[j].date)
+		}
+		return seq(events[i].e) < seq(events[j].e)
+	})
+	sort.SliceStable(b.Lots, func(i, j int) bool {
+		return b.Lots[i].Acquired.Before(b.Lots[j].Acquired)
+	})
+
+	if n := len(events); n > 0 && events[n-1].date.After(b.Through) {
+		b.Through = events[n-1].date
+	}
+	for _, ev := range events {
+		var err error
+		switch {
+		case ev.lot != nil:
+			for l := ev.lot; l != nil; l = l.next {
+				l.opened = true
+				b.open[l.key()] = append(b.open[l.key()], l)
+			}
+		case sellActions[ev.e["Action"]]:
+			err = b.sell(ev.e, ev.date)
+		case saleActions[ev.e["Action"]]:
+			err = b.sale(ev.e, ev.date)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, err := range b.Check() {
+		log.Print(err)
+	}
+	return nil
+}
+
+// Check the book's invariants: no lot is oversold, and losses
+// disallowed by wash sales are conserved, each sale's going to its
+// replacements and amounting to no more than its loss. A violation
+// is a bug.
+func (b *Book) Check() []error {
+	var errs []error
+	for _, l := range b.Lots {
+		if l.Open.Sign() < 0 || l.Open.Cmp(l.Shares) > 0 {
+			errs = append(errs, fmt.Errorf("%s %s lot of %s: %s open", formatDate(l.Acquired), l.Symbol, l.Shares, l.Open))
+		}
+	}
+
+	washed := make(map[*Sale]Decimal)
+	shares := make(map[*Sale]Decimal)
+	for _, w := range b.Washes {
+		washed[w.Sale] = washed[w.Sale].Add(w.Disallowed)
+		shares[w.Sale] = shares[w.Sale].Add(w.Shares)
+	}
+	for _, s := range b.Sales {
+		what := fmt.Sprintf("%s %s sale of %s", formatDate(s.Sold), s.Symbol, s.Shares)
+		switch {
+		case washed[s].Cmp(s.Disallowed) != 0:
+			errs = append(errs, fmt.Errorf("%s: %s disallowed, but %s moved to replacements", what, s.Disallowed, washed[s]))
+		case s.Disallowed.Sign() != 0 && s.Disallowed.Cmp(s.Gain().Neg()) > 0:
+			errs = append(errs, fmt.Errorf("%s: %s disallowed exceeds the loss", what, s.Disallowed))
+		case shares[s].Cmp(s.Shares) > 0:
+			errs = append(errs, fmt.Errorf("%s: %s shares replaced", what, shares[s]))
+		}
+	}
+	return errs
+}
+
+func newLot(e map[string]string, date time.Time) (*Lot, error) {
+	if e["Action"] == "Lot" {
+		return carriedLot(e, date)
+	}
+
+	shares, ok := first(e, sharesKeys)
+	if !ok {
+		return nil, fmt.Errorf("%s %s: no share count", e["Date"], e["Action"])
+	}
+	cost, ok := first(e, costKeys)
+	if !ok {
+		return nil, fmt.Errorf("%s %s: no cost", e["Date"], e["Action"])
+	}
+	return &Lot{
+		Source:   e["Source"],
+		Owner:    owner(e),
+		Account:  account(e["Action"]),
+		Symbol:   e["Symbol"],
+		Award:    e["Award ID"],
+		Acquired: date,
+		Since:    date,
+		Shares:   shares,
+		Open:     shares,
+		Basis:    shares.Mul(cost),
+	}, nil
+}
+
+// A lot carried over from an earlier run, with its (possibly
+// adjusted) total basis and holding period.
+func carriedLot(e map[string]string, date time.Time) (*Lot, error) {
+	shares, ok := amount(e, "Shares")
+	if !ok {
+		return nil, fmt.Errorf("%s %s: no share count", e["Date"], e["Action"])
+	}
+	basis, ok := amount(e, "Basis")
+	if !ok {
+		return nil, fmt.Errorf("%s %s: no basis", e["Date"], e["Action"])
+	}
+	since := date
+	if e["Holding Since"] != "" {
+		var err error
+		if since, err = parseDate(e["Holding Since"]); err != nil {
+			return nil, err
+		}
+	}
+	acct := e["Account"]
+	if acct == "" {
+		acct = account("")
+	}
+	return &Lot{
+		Source:   e["Source"],
+		Owner:    owner(e),
+		Account:  acct,
+		Symbol:   e["Symbol"],
+		Acquired: date,
+		Since:    since,
+		Shares:   shares,
+		Open:     shares,
+		Basis:    basis,
+	}, nil
+}
+
+// Render the open lots as entries, which may be given to a later
+// run to carry them over. They are stamped "As Of" the date
+// through which they account for the history, if known.
+func lotEntries(lots []*Lot, through time.Time) []map[string]string {
+	var entries []map[string]string
+	for _, l := range lots {
+		if l.Open.Sign() == 0 {
+			continue
+		}
+		entries = append(entries, map[string]string{
+			"Action":        "Lot",
+			"Date":          formatDate(l.Acquired),
+			"Holding Since": formatDate(l.Since),
+			"Source":        l.Source,
+			"Account":       l.Account,
+			"Symbol":        l.Symbol,
+			"Shares":        l.Open.String(),
+			"Basis":         l.Basis.String(),
+		})
+		if l.Owner != "" {
+			entries[len(entries)-1]["Account Name"] = l.Owner
+		}
+		if !through.IsZero() {
+			entries[len(entries)-1]["As Of"] = formatDate(through)
+		}
+	}
+	return entries
+}
+
+// Drop the entries accounted for by lots carried over from an
+// earlier run: those acquiring or disposing of shares on or before
+// the latest "As Of" date among the carried lots. This lets a run
+// be given the lots from last year's along with histories that
+// overlap it. The date is returned too.
+func carryOver(entries []map[string]string) ([]map[string]string, time.Time) {
+	var asOf time.Time
+	for _, e := range entries {
+		if e["Action"] != "Lot" {
+			continue
+		}
+		if d, err := parseDate(e["As Of"]); err == nil && d.After(asOf) {
+			asOf = d
+		}
+	}
+	if asOf.IsZero() {
+		return entries, asOf
+	}
+
+	var kept []map[string]string
+	for _, e := range entries {
+		action := e["Action"]
+		if action != "Lot" && (buyActions[action] || sellActions[action] || saleActions[action] || derActions[action]) {
+			when := e["Date"]
+			if derActions[action] && e["Vest Date"] != "" {
+				when = e["Vest Date"]
+			}
+			if d, err := parseDate(when); err == nil && !d.After(asOf) {
+				continue
+			}
+		}
+		kept = append(kept, e)
+	}
+	return kept, asOf
+}
+
+// Write the book's open lots as JSON.
+func writeLots(w io.Writer, b *Book) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(lotEntries(b.Lots, b.Through))
+}
+
+// Write the open lots left by the entries from the given
+// sources, as JSON, for a later run.
+func exportLots(args []string) error {
+	b, err := book(args)
+	if err != nil {
+		return err
+	}
+	return writeLots(os.Stdout, b)
+}
+
+// Carry the lots exported by an earlier run through the entries
+// from the given sources, writing out the lots left open. Entries
+// the lots already account for are dropped, so a year's run needs
+// only last year's lots and its own history.
+func importLots(args []string) error {
+	if len(args) < 1 {
+		return errors.New("usage: eac2json lots import lots.json [file...]")
+	}
+	lots, err := load(args[:1])
+	if err != nil {
+		return err
+	}
+	for _, e := range lots {
+		if e["Action"] != "Lot" {
+			return fmt.Errorf("%s: not exported lots", args[0])
+		}
+	}
+	b, err := book(args)
+	if err != nil {
+		return err
+	}
+	return writeLots(os.Stdout, b)
+}
+
+// Sell shares from open lots in the account, first in, first out.
+func (b *Book) sell(e map[string]string, date time.Time) error {
+	shares, ok := first(e, sharesKeys)
+	if !ok {
+		return fmt.Errorf("%s %s: no share count", e["Date"], e["Action"])
+	}
+	price, ok := first(e, saleKeys)
+	if !ok {
+		return fmt.Errorf("%s %s: no sale price", e["Date"], e["Action"])
+	}
+	fees, _ := amount(e, "Fees & Commissions")
+
+	sym := e["Symbol"]
+	acct := account(e["Action"])
+	total := shares
+	for shares.Sign() > 0 {
+		// The default owner's carried lots are the oldest, so
+		// they are sold first.
+		var lot *Lot
+		if open := b.open[lotKey("", acct, sym)]; len(open) > 0 {
+			lot = open[0]
+		} else if open := b.open[lotKey(owner(e), acct, sym)]; len(open) > 0 {
+			lot = open[0]
+		} else {
+			// We don't know where these came from. Sell them
+			// from an empty lot so the sale is still recorded.
+			log.Printf("%s %s %s: selling %s shares not held", e["Date"], e["Action"], sym, shares)
+			lot = &Lot{Source: e["Source"], Owner: owner(e), Account: acct, Symbol: sym, Acquired: date, Since: date, Shares: shares, Open: shares}
+		}
+
+		n := shares
+		if lot.Open.Cmp(n) < 0 {
+			n = lot.Open
+		}
+		// Fees are shared pro rata across the lots sold.
+		proceeds := n.Mul(price).Sub(fees.Mul(n).Quo(total))
+		b.take(e, lot, n, proceeds, date)
+		shares = shares.Sub(n)
+	}
+
+	return nil
+}
+
+// A sale of shares acquired at the same time (exercise and sell).
+func (b *Book) sale(e map[string]string, date time.Time) error {
+	acquired := date
+	if d, err := parseDate(e["Purchase Date"]); err == nil {
+		acquired = d
+	}
+	lot, err := newLot(e, acquired)
+	if err != nil {
+		return err
+	}
+	if lot.Open.Sign() == 0 {
+		return nil
+	}
+	price, ok := first(e, saleKeys)
+	if !ok {
+		return fmt.Errorf("%s %s: no sale price", e["Date"], e["Action"])
+	}
+	b.take(e, lot, lot.Open, lot.Open.Mul(price), date)
+	return nil
+}
+
+// Take n shares from the lot for a sale.
+func (b *Book) take(e map[string]string, lot *Lot, n, proceeds Decimal, date time.Time) {
+	basis := lot.Basis.Mul(n).Quo(lot.Open)
+	lot.Basis = lot.Basis.Sub(basis)
+	lot.Open = lot.Open.Sub(n)
+	if lot.Open.Sign() == 0 {
+		b.close(lot)
+	}
+
+	s := &Sale{
+		Source:   e["Source"],
+		Symbol:   lot.Symbol,
+		Sold:     date,
+		Acquired: lot.Since,
+		Shares:   n,
+		Proceeds: proceeds,
+		Basis:    basis,
+		lot:      lot,
+	}
+	b.Sales = append(b.Sales, s)
+	if s.Gain().Sign() < 0 {
+		b.wash(s)
+	}
+}
+
+// Remove a lot from the open lots.
+func (b *Book) close(lot *Lot) {
+	open := b.open[lot.key()]
+	for i, l := range open {
+		if l == lot {
+			b.open[lot.key()] = append(open[:i:i], open[i+1:]...)
+			return
+		}
+	}
+}
+
+// Split n open shares off a lot into a new lot, which is placed
+// after it.
+func (b *Book) split(lot *Lot, n Decimal) *Lot {
+	basis := lot.Basis.Mul(n).Quo(lot.Open)
+	l := new(Lot)
+	*l = *lot
+	l.Shares, l.Open, l.Basis = n, n, basis
+	l.next = lot.next
+	lot.Shares, lot.Open, lot.Basis = lot.Shares.Sub(n), lot.Open.Sub(n), lot.Basis.Sub(basis)
+
+	insert := func(lots []*Lot) []*Lot {
+		for i, x := range lots {
+			if x == lot {
+				lots = append(lots[:i+1], append([]*Lot{l}, lots[i+1:]...)...)
+				break
+			}
+		}
+		return lots
+	}
+	b.Lots = insert(b.Lots)
+	if lot.opened {
+		b.open[lot.key()] = insert(b.open[lot.key()])
+	} else {
+		lot.next = l
+	}
+	return l
+}
+
+// The ordinal of an entry in the input, or 0 if it has none.
+func seq(e map[string]string) int {
+	n, _ := strconv.Atoi(e["Seq"])
+	return n
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Generate a history of vests and sales of three symbols, two of
+// them substantially identical, never selling more than is held.
+// Prices swing widely, so that many sales are losses and many of
+// those are washed.
+func randomHistory(r *rand.Rand) []map[string]string {
+	var (
+		entries []map[string]string
+		held    = make(map[string]int)
+		date    = time.Date(2022, 1, 3, 0, 0, 0, 0, time.UTC)
+	)
+	for i := 0; i < 5+r.Intn(60); i++ {
+		date = date.AddDate(0, 0, r.Intn(15))
+		sym := []string{"GOOG", "GOOGL", "MSFT"}[r.Intn(3)]
+		price := fmt.Sprintf("$%d.%02d", 20+r.Intn(200), r.Intn(100))
+		e := map[string]string{
+			"Date":   formatDate(date),
+			"Symbol": sym,
+			"Seq":    strconv.Itoa(i),
+		}
+		if held[sym] == 0 || r.Intn(2) == 0 {
+			n := 1 + r.Intn(100)
+			e["Action"] = "Release"
+			e["Shares"] = strconv.Itoa(n)
+			e["Fair Market Value"] = price
+			held[sym] += n
+		} else {
+			n := 1 + r.Intn(held[sym])
+			e["Action"] = "Sell"
+			e["Shares"] = strconv.Itoa(n)
+			e["Sale Price"] = price
+			if r.Intn(3) == 0 {
+				e["Fees & Commissions"] = fmt.Sprintf("$%d.%02d", r.Intn(10), r.Intn(100))
+			}
+			held[sym] -= n
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func runRandom(t *testing.T, seed int64) (*Book, []map[string]string) {
+	t.Helper()
+	entries := randomHistory(rand.New(rand.NewSource(seed)))
+	b := &Book{Window: washDays}
+	(&WashConfig{Window: washDays, Identical: [][]string{{"GOOG", "GOOGL"}}}).Configure(b)
+	if err := b.Run(entries); err != nil {
+		t.Fatalf("seed %d: %s", seed, err)
+	}
+	return b, entries
+}
+
+func TestBookInvariants(t *testing.T) {
+	for seed := int64(0); seed < 500; seed++ {
+		b, _ := runRandom(t, seed)
+		for _, err := range b.Check() {
+			t.Errorf("seed %d: %s", seed, err)
+		}
+	}
+}
+
+// Shares acquired are either sold or still open, and no more
+// shares are sold than were.
+func TestBookConservesShares(t *testing.T) {
+	for seed := int64(0); seed < 500; seed++ {
+		b, entries := runRandom(t, seed)
+		acquired := make(map[string]Decimal)
+		sold := make(map[string]Decimal)
+		for _, e := range entries {
+			n, _ := amount(e, "Shares")
+			switch e["Action"] {
+			case "Release":
+				acquired[e["Symbol"]] = acquired[e["Symbol"]].Add(n)
+			case "Sell":
+				sold[e["Symbol"]] = sold[e["Symbol"]].Add(n)
+			}
+		}
+
+		open := make(map[string]Decimal)
+		for _, l := range b.Lots {
+			open[l.Symbol] = open[l.Symbol].Add(l.Open)
+		}
+		taken := make(map[string]Decimal)
+		for _, s := range b.Sales {
+			taken[s.Symbol] = taken[s.Symbol].Add(s.Shares)
+		}
+		for sym, n := range acquired {
+			if got := open[sym].Add(taken[sym]); got.Cmp(n) != 0 {
+				t.Errorf("seed %d: %s: %s acquired, but %s open and %s sold", seed, sym, n, open[sym], taken[sym])
+			}
+			if taken[sym].Cmp(sold[sym]) != 0 {
+				t.Errorf("seed %d: %s: %s sold, but %s taken from lots", seed, sym, sold[sym], taken[sym])
+			}
+		}
+	}
+}
+
+// Basis is neither made nor lost: what the lots cost is what's
+// left in them and what was sold, less the losses disallowed, which
+// move into the replacements' basis.
+func TestBookConservesBasis(t *testing.T) {
+	for seed := int64(0); seed < 500; seed++ {
+		b, entries := runRandom(t, seed)
+		var cost, left, sold, disallowed Decimal
+		for _, e := range entries {
+			if e["Action"] == "Release" {
+				n, _ := amount(e, "Shares")
+				p, _ := amount(e, "Fair Market Value")
+				cost = cost.Add(n.Mul(p))
+			}
+		}
+		for _, l := range b.Lots {
+			left = left.Add(l.Basis)
+		}
+		for _, s := range b.Sales {
+			sold = sold.Add(s.Basis)
+			disallowed = disallowed.Add(s.Disallowed)
+		}
+		if got := left.Add(sold).Sub(disallowed); got.Cmp(cost) != 0 {
+			t.Errorf("seed %d: lots cost %s, but %s left and %s sold, with %s disallowed", seed, cost, left, sold, disallowed)
+		}
+	}
+}
+
+// Sales never gain from being washed: the loss disallowed is no
+// more than the loss.
+func TestBookWashesOnlyLosses(t *testing.T) {
+	for seed := int64(0); seed < 500; seed++ {
+		b, _ := runRandom(t, seed)
+		for _, s := range b.Sales {
+			if s.Disallowed.Sign() < 0 {
+				t.Errorf("seed %d: %s %s sale: %s disallowed", seed, formatDate(s.Sold), s.Symbol, s.Disallowed)
+			}
+			if s.Disallowed.Sign() > 0 && s.Gain().Sign() >= 0 {
+				t.Errorf("seed %d: %s %s sale: gain of %s washed", seed, formatDate(s.Sold), s.Symbol, s.Gain())
+			}
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"hash"
+	"io"
+	"os"
+	"runtime/debug"
+	"strings"
+)
+
+// A manifest records how a run's output was produced: by which
+// version, with which flags and rules, from which inputs. It is
+// written with -manifest, so that an archive can later show where
+// its numbers came from.
+type manifest struct {
+	Version       string            `json:"version"`
+	SchemaVersion int               `json:"schema_version"`
+	Args          []string          `json:"args"`
+	Flags         map[string]string `json:"flags"`
+	Rules         string            `json:"rules"`
+	Inputs        []input           `json:"inputs"`
+}
+
+type input struct {
+	File   string `json:"file"`
+	SHA256 string `json:"sha256"`
+}
+
+// The inputs read by load, and by the readers of the files given
+// by flags (see openInput), and the hash of the rules load used.
+var (
+	inputs    []input
+	rulesHash string
+)
+
+// Read r through to the end, hashing it as the named input.
+type hashReader struct {
+	io.Reader
+	h    hash.Hash
+	file string
+}
+
+func newHashReader(r io.Reader, file string) *hashReader {
+	h := sha256.New()
+	return &hashReader{io.TeeReader(r, h), h, file}
+}
+
+// Record the input, reading whatever its parser left.
+func (r *hashReader) Done() error {
+	if _, err := io.Copy(io.Discard, r.Reader); err != nil {
+		return err
+	}
+	inputs = append(inputs, input{r.file, hex.EncodeToString(r.h.Sum(nil))})
+	return nil
+}
+
+// An inputFile is an input other than a source, such as -overlay,
+// hashed as it is read and recorded when closed.
+type inputFile struct {
+	*hashReader
+	f *os.File
+}
+
+func openInput(file string) (io.ReadCloser, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	return &inputFile{newHashReader(f, file), f}, nil
+}
+
+func (f *inputFile) Close() error {
+	err := f.Done()
+	if cerr := f.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Read an input as openInput does, all at once.
+func readInput(file string) ([]byte, error) {
+	f, err := openInput(file)
+	if err != nil {
+		return nil, err
+	}
+	b, err := io.ReadAll(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return b, err
+}
+
+// Redact the values of secret flags in the arguments, as given
+// either as -flag=value or as -flag value.
+func redactArgs(args []string) []string {
+	args = append([]string(nil), args...)
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if a == "--" {
+			break
+		}
+		if !strings.HasPrefix(a, "-") {
+			continue
+		}
+		name := strings.TrimLeft(a, "-")
+		if j := strings.Index(name, "="); j >= 0 {
+			if secretFlags[name[:j]] {
+				args[i] = a[:len(a)-len(name)+j+1] + redacted
+			}
+		} else if secretFlags[name] && i+1 < len(args) {
+			i++
+			args[i] = redacted
+		}
+	}
+	return args
+}
+
+// The hash of a rule set.
+func hashRules(rules []Rule) string {
+	b, _ := json.Marshal(rules)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// The version of the program, with the revision it was built
+// from, if known, marked if the tree was modified.
+func version() string {
+	v := "(devel)"
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return v
+	}
+	if bi.Main.Version != "" {
+		v = bi.Main.Version
+	}
+	var rev, modified string
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			rev = s.Value
+		case "vcs.modified":
+			modified = s.Value
+		}
+	}
+	if rev != "" {
+		v += " " + rev
+		if modified == "true" {
+			v += "+modified"
+		}
+	}
+	return v
+}
+
+// Flags whose values are secrets, and so are not recorded.
+var secretFlags = map[string]bool{"cookie": true, "token": true}
+
+const redacted = "(redacted)"
+
+// Write the manifest of the run to file. Flags are recorded if
+// they differ from their defaults, however they were set, but the
+// values of secrets, and of flags set from the environment (see
+// envFlags), which may be secrets too, are redacted.
+func writeManifest(file string, args []string) error {
+	m := manifest{
+		Version:       version(),
+		SchemaVersion: schemaVersion,
+		Args:          redactArgs(args),
+		Flags:         make(map[string]string),
+		Rules:         rulesHash,
+		Inputs:        inputs,
+	}
+	flag.VisitAll(func(f *flag.Flag) {
+		v := f.Value.String()
+		if v == f.DefValue || f.Name == "manifest" {
+			return
+		}
+		if ev, ok := envValues[f.Name]; secretFlags[f.Name] || ok && ev == v {
+			v = redacted
+		}
+		m.Flags[f.Name] = v
+	})
+
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "\t")
+	if err := enc.Encode(m); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
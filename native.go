@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The largest message a browser accepts from a native host.
+const maxNativeMessage = 1 << 20
+
+// A message from the companion extension: a page it grabbed, as
+// rendered, with its URL. Large pages may be sent in pieces, all
+// but the last with More set; they are concatenated.
+//
+//	{"id": "1", "type": "page", "url": "https://...", "html": "<html>..."}
+type nativeRequest struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	URL  string `json:"url"`
+	HTML string `json:"html"`
+	More bool   `json:"more"`
+}
+
+// A message to the extension. A page is answered by any number of
+// "entries" messages, each small enough for the browser to accept,
+// followed by "done" with their total count; or by "error".
+//
+//	{"id": "1", "type": "entries", "entries": [...]}
+//	{"id": "1", "type": "done", "count": 12}
+//	{"id": "1", "type": "error", "error": "..."}
+type nativeResponse struct {
+	ID      string        `json:"id"`
+	Type    string        `json:"type"`
+	Entries []interface{} `json:"entries,omitempty"`
+	Count   int           `json:"count,omitempty"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// Act as the native messaging host of a companion browser
+// extension, converting the pages it sends, so that they needn't
+// be saved first; nothing leaves the machine. Messages are JSON,
+// each preceded by its length as a 32-bit integer in native byte
+// order, on standard input and output.
+//
+// Browsers run the host named by its manifest with arguments of
+// their own, so the manifest should name a script that runs
+// "eac2json native", with any flags; the arguments are ignored.
+func nativeCommand(args []string) error {
+	pages := make(map[string]*strings.Builder)
+	for {
+		var req nativeRequest
+		switch err := readNative(os.Stdin, &req); err {
+		case nil:
+		case io.EOF:
+			return nil
+		case errNativeTooLarge:
+			if err := writeNative(os.Stdout, nativeResponse{Type: "error", Error: err.Error()}); err != nil {
+				return err
+			}
+			continue
+		default:
+			return err
+		}
+
+		if req.Type != "page" {
+			err := writeNative(os.Stdout, nativeResponse{ID: req.ID, Type: "error",
+				Error: fmt.Sprintf("bad message type %q", req.Type)})
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		b := pages[req.ID]
+		if b == nil {
+			b = new(strings.Builder)
+			pages[req.ID] = b
+		}
+		b.WriteString(req.HTML)
+		if req.More {
+			continue
+		}
+		delete(pages, req.ID)
+
+		if err := nativePage(req.ID, req.URL, b.String()); err != nil {
+			return err
+		}
+	}
+}
+
+// Convert a page, and send its entries. Errors converting it are
+// sent to the extension; only errors writing to it are returned.
+func nativePage(id, url, page string) error {
+	entries, err := convertPage(url, page)
+	if err != nil {
+		return writeNative(os.Stdout, nativeResponse{ID: id, Type: "error", Error: err.Error()})
+	}
+
+	// Leave room for the envelope. An entry too large to send on
+	// its own fails the page, before any are sent.
+	const room = maxNativeMessage - 1024
+	out := render(window(entries))
+	sizes := make([]int, len(out))
+	for i, e := range out {
+		b, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if len(b) > room {
+			return writeNative(os.Stdout, nativeResponse{ID: id, Type: "error",
+				Error: fmt.Sprintf("entry %s is too large to send (%d bytes)", entries[i]["ID"], len(b))})
+		}
+		sizes[i] = len(b)
+	}
+
+	var batch []interface{}
+	size := 0
+	for i, e := range out {
+		if len(batch) > 0 && size+sizes[i] > room {
+			if err := writeNative(os.Stdout, nativeResponse{ID: id, Type: "entries", Entries: batch}); err != nil {
+				return err
+			}
+			batch, size = nil, 0
+		}
+		batch = append(batch, e)
+		size += sizes[i] + 1
+	}
+	if len(batch) > 0 {
+		if err := writeNative(os.Stdout, nativeResponse{ID: id, Type: "entries", Entries: batch}); err != nil {
+			return err
+		}
+	}
+	return writeNative(os.Stdout, nativeResponse{ID: id, Type: "done", Count: len(out)})
+}
+
+// Convert a page as if it were saved, with its URL as its source.
+// It is saved alone in a directory of its own, as by serve, so that
+// its frames can't name other files.
+func convertPage(url, page string) ([]map[string]string, error) {
+	dir, err := os.MkdirTemp("", "eac2json")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "page")
+	if err := os.WriteFile(file, []byte(page), 0600); err != nil {
+		return nil, err
+	}
+
+	entries, err := load([]string{file})
+	if err != nil {
+		name := url
+		if name == "" {
+			name = "page"
+		}
+		return nil, errors.New(strings.Replace(err.Error(), file, name, -1))
+	}
+	if url != "" {
+		for _, e := range entries {
+			e["Source"] = url
+		}
+	}
+	return entries, nil
+}
+
+var errNativeTooLarge = fmt.Errorf("message larger than %d bytes", maxNativeMessage)
+
+// Read a message. One larger than maxNativeMessage is skipped, with
+// errNativeTooLarge; large pages must be sent in pieces.
+func readNative(r io.Reader, v interface{}) error {
+	var n uint32
+	if err := binary.Read(r, binary.NativeEndian, &n); err != nil {
+		return err
+	}
+	if n > maxNativeMessage {
+		if _, err := io.CopyN(io.Discard, r, int64(n)); err != nil {
+			return err
+		}
+		return errNativeTooLarge
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func writeNative(w io.Writer, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if len(b) > maxNativeMessage {
+		return fmt.Errorf("message of %d bytes is too large", len(b))
+	}
+	if err := binary.Write(w, binary.NativeEndian, uint32(len(b))); err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Strip a page down to what's parsed: scripts, styles, comments,
+// and tracking iframes are removed, and runs of whitespace are
+// collapsed, dropping text that is only whitespace. Saved pages are
+// mostly these; without them they are much quicker to traverse.
+func normalize(n *html.Node) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		switch {
+		case c.Type == html.CommentNode || c.Type == html.ElementNode && junk(c):
+			n.RemoveChild(c)
+		case c.Type == html.TextNode && !preformatted(n):
+			if strings.TrimSpace(c.Data) == "" {
+				n.RemoveChild(c)
+			} else {
+				c.Data = whitespace.ReplaceAllString(c.Data, " ")
+			}
+		default:
+			normalize(c)
+		}
+		c = next
+	}
+}
+
+var whitespace = regexp.MustCompile(`\s+`)
+
+// Whether an element is of no use to parsing.
+func junk(n *html.Node) bool {
+	switch n.Data {
+	case "script", "noscript", "style", "template":
+		return true
+	case "link":
+		return strings.EqualFold(attr(n, "rel"), "stylesheet") ||
+			strings.EqualFold(attr(n, "rel"), "preload")
+	case "iframe":
+		return tracking(n)
+	}
+	return false
+}
+
+// Trackers are iframes that can't be seen: hidden, or a pixel.
+// Those with a document, by src or srcdoc, are kept all the same,
+// as the history may be in one (see parseFrames).
+func tracking(n *html.Node) bool {
+	if hasAttr(n, "src") || hasAttr(n, "srcdoc") {
+		return false
+	}
+	style := strings.ToLower(strings.Replace(attr(n, "style"), " ", "", -1))
+	switch {
+	case hasAttr(n, "hidden"),
+		strings.Contains(style, "display:none"),
+		strings.Contains(style, "visibility:hidden"):
+		return true
+	}
+	small := func(v string) bool {
+		v = strings.TrimSuffix(strings.TrimSpace(v), "px")
+		return v == "0" || v == "1"
+	}
+	return small(attr(n, "width")) || small(attr(n, "height"))
+}
+
+func preformatted(n *html.Node) bool {
+	for ; n != nil; n = n.Parent {
+		if n.Type == html.ElementNode && (n.Data == "pre" || n.Data == "textarea") {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAttr(n *html.Node, key string) bool {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// Write a saved page (HTML or MHTML), normalized, to standard
+// output; e.g. to shrink a page before filing it with a bug report.
+func normalizeCommand(args []string) error {
+	var r io.Reader = os.Stdin
+	switch len(args) {
+	case 0:
+	case 1:
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	default:
+		return errors.New("usage: eac2json normalize [page]")
+	}
+
+	br := bufio.NewReader(r)
+	kind, err := sniff(br)
+	if err != nil {
+		return err
+	}
+	switch kind {
+	case "html":
+		r = br
+	case "mhtml":
+		if r, _, err = mhtmlDocument(br); err != nil {
+			return err
+		}
+	default:
+		return errors.New("not a saved page")
+	}
+
+	doc, err := html.Parse(r)
+	if err != nil {
+		return err
+	}
+	normalize(doc)
+	w := bufio.NewWriter(os.Stdout)
+	if err := html.Render(w, doc); err != nil {
+		return err
+	}
+	return w.Flush()
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// An ofxWriter writes OFX 1.02 (SGML), as Quicken's Web Connect
+// expects: aggregates are closed, elements are not.
+type ofxWriter struct {
+	*bufio.Writer
+}
+
+func (w ofxWriter) open(tag string)  { fmt.Fprintf(w, "<%s>\n", tag) }
+func (w ofxWriter) close(tag string) { fmt.Fprintf(w, "</%s>\n", tag) }
+
+func (w ofxWriter) elem(tag, v string) {
+	r := strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	fmt.Fprintf(w, "<%s>%s\n", tag, r.Replace(v))
+}
+
+func (w ofxWriter) status() {
+	w.open("STATUS")
+	w.elem("CODE", "0")
+	w.elem("SEVERITY", "INFO")
+	w.close("STATUS")
+}
+
+func ofxDate(t time.Time) string {
+	return t.Format("20060102")
+}
+
+// Write the history as a Quicken Web Connect (QFX) file. With the
+// default -account-type, investment, it is an investment statement
+// of the transactions (see qfxTransaction) for the broker
+// -broker-id and account -account-id; with checking, savings, or
+// moneymrkt, it is a bank statement of the cash history, with the
+// balance its sum.
+// With -fid, the file names the institution, as -org, so that
+// Quicken matches it to the account it's been given before, rather
+// than asking which account it's for.
+func qfxCommand(args []string) error {
+	entries, err := load(args)
+	if err != nil {
+		return err
+	}
+	entries = window(entries)
+
+	var (
+		lo, hi time.Time
+		dated  []map[string]string
+		cash   = *accountTypeFlag != "investment"
+	)
+	for _, e := range entries {
+		if cash != (e["Record"] == "cash") || e["Action"] == "Lot" {
+			continue
+		}
+		d, err := parseDate(e["Date"])
+		if err != nil {
+			return err
+		}
+		if lo.IsZero() || d.Before(lo) {
+			lo = d
+		}
+		if d.After(hi) {
+			hi = d
+		}
+		dated = append(dated, e)
+	}
+	if len(dated) == 0 {
+		return errors.New("no transactions")
+	}
+
+	w := ofxWriter{bufio.NewWriter(os.Stdout)}
+	fmt.Fprint(w, "OFXHEADER:100\nDATA:OFXSGML\nVERSION:102\nSECURITY:NONE\nENCODING:USASCII\nCHARSET:1252\nCOMPRESSION:NONE\nOLDFILEUID:NONE\nNEWFILEUID:NONE\n\n")
+	w.open("OFX")
+	w.open("SIGNONMSGSRSV1")
+	w.open("SONRS")
+	w.status()
+	w.elem("DTSERVER", time.Now().UTC().Format("20060102150405"))
+	w.elem("LANGUAGE", "ENG")
+	if *fidFlag != "" {
+		w.open("FI")
+		w.elem("ORG", *orgFlag)
+		w.elem("FID", *fidFlag)
+		w.close("FI")
+		w.elem("INTU.BID", *fidFlag)
+	}
+	w.close("SONRS")
+	w.close("SIGNONMSGSRSV1")
+
+	if cash {
+		err = qfxBank(w, dated, lo, hi)
+	} else {
+		err = qfxInvestment(w, dated, lo, hi)
+	}
+	if err != nil {
+		return err
+	}
+	w.close("OFX")
+	return w.Flush()
+}
+
+func qfxBank(w ofxWriter, entries []map[string]string, lo, hi time.Time) error {
+	w.open("BANKMSGSRSV1")
+	w.open("STMTTRNRS")
+	w.elem("TRNUID", "0")
+	w.status()
+	w.open("STMTRS")
+	w.elem("CURDEF", "USD")
+	w.open("BANKACCTFROM")
+	w.elem("BANKID", *brokerIDFlag)
+	w.elem("ACCTID", *accountIDFlag)
+	w.elem("ACCTTYPE", strings.ToUpper(*accountTypeFlag))
+	w.close("BANKACCTFROM")
+	w.open("BANKTRANLIST")
+	w.elem("DTSTART", ofxDate(lo))
+	w.elem("DTEND", ofxDate(hi))
+	var balance Decimal
+	for _, e := range entries {
+		v, ok := amount(e, "Amount")
+		if !ok {
+			continue
+		}
+		d, _ := parseDate(e["Date"])
+		typ := "CREDIT"
+		if v.Sign() < 0 {
+			typ = "DEBIT"
+		}
+		w.open("STMTTRN")
+		w.elem("TRNTYPE", typ)
+		w.elem("DTPOSTED", ofxDate(d))
+		w.elem("TRNAMT", v.Fixed(2))
+		w.elem("FITID", e["ID"])
+		w.elem("NAME", e["Action"])
+		if e["Description"] != "" {
+			w.elem("MEMO", e["Description"])
+		}
+		w.close("STMTTRN")
+		balance = balance.Add(v)
+	}
+	w.close("BANKTRANLIST")
+	w.open("LEDGERBAL")
+	w.elem("BALAMT", balance.Fixed(2))
+	w.elem("DTASOF", ofxDate(hi))
+	w.close("LEDGERBAL")
+	w.close("STMTRS")
+	w.close("STMTTRNRS")
+	w.close("BANKMSGSRSV1")
+	return nil
+}
+
+func qfxInvestment(w ofxWriter, entries []map[string]string, lo, hi time.Time) error {
+	w.open("INVSTMTMSGSRSV1")
+	w.open("INVSTMTTRNRS")
+	w.elem("TRNUID", "0")
+	w.status()
+	w.open("INVSTMTRS")
+	w.elem("DTASOF", ofxDate(hi))
+	w.elem("CURDEF", "USD")
+	w.open("INVACCTFROM")
+	w.elem("BROKERID", *brokerIDFlag)
+	w.elem("ACCTID", *accountIDFlag)
+	w.close("INVACCTFROM")
+	w.open("INVTRANLIST")
+	w.elem("DTSTART", ofxDate(lo))
+	w.elem("DTEND", ofxDate(hi))
+	symbols := make(map[string]bool)
+	for i, ev := range modelEvents(entries) {
+		ok, err := qfxTransaction(w, ev.(*modelEvent), entries[i])
+		if err != nil {
+			return err
+		}
+		if ok {
+			symbols[entries[i]["Symbol"]] = true
+		}
+	}
+	w.close("INVTRANLIST")
+	w.close("INVSTMTRS")
+	w.close("INVSTMTTRNRS")
+	w.close("INVSTMTMSGSRSV1")
+
+	var syms []string
+	for s := range symbols {
+		syms = append(syms, s)
+	}
+	sort.Strings(syms)
+	w.open("SECLISTMSGSRSV1")
+	w.open("SECLIST")
+	for _, s := range syms {
+		w.open("STOCKINFO")
+		w.open("SECINFO")
+		w.open("SECID")
+		w.elem("UNIQUEID", s)
+		w.elem("UNIQUEIDTYPE", "TICKER")
+		w.close("SECID")
+		w.elem("SECNAME", s)
+		w.elem("TICKER", s)
+		w.close("SECINFO")
+		w.close("STOCKINFO")
+	}
+	w.close("SECLIST")
+	w.close("SECLISTMSGSRSV1")
+	return nil
+}
+
+// Write the investment transaction of an entry, as typed by its
+// event (see modelEvents), reporting whether it has one: vests, and
+// deposits and journals of shares, are transfers in; buys, and
+// exercises, at the strike price, are buys; sales are sells, with
+// their commissions and taxes, and, if the shares were acquired with
+// the sale, their transfer in; dividends paid in cash are income, and
+// reinvested ones reinvestments. Dividend equivalents are transfers
+// in once they vest. Transactions are identified by their entries'
+// IDs, so that Quicken skips those already imported; plain buys and
+// sells keep the IDs they were first exported with, ID-Buy and
+// ID-Sell.
+func qfxTransaction(w ofxWriter, ev *modelEvent, e map[string]string) (bool, error) {
+	when := e["Date"]
+	if derActions[e["Action"]] {
+		if e["Vest Date"] == "" {
+			return false, nil
+		}
+		when = e["Vest Date"]
+	}
+	date, err := parseDate(when)
+	if err != nil {
+		return false, err
+	}
+	id := e["ID"]
+	switch e["Action"] {
+	case "Buy", "Sell":
+		id += "-" + e["Action"]
+	}
+	units, haveUnits := first(e, sharesKeys)
+	price, _ := parseDecimal(ev.Price)
+	fees, _ := amount(e, "Fees & Commissions")
+	taxes, _ := amount(e, "Taxes")
+	cash, haveCash := amount(e, "Amount")
+
+	invtran := func(id string, date time.Time) {
+		w.open("INVTRAN")
+		w.elem("FITID", id)
+		w.elem("DTTRADE", ofxDate(date))
+		if e["Description"] != "" {
+			w.elem("MEMO", e["Description"])
+		}
+		w.close("INVTRAN")
+		w.open("SECID")
+		w.elem("UNIQUEID", e["Symbol"])
+		w.elem("UNIQUEIDTYPE", "TICKER")
+		w.close("SECID")
+	}
+	transferIn := func(id string, date time.Time) {
+		cost := price
+		if c, ok := first(e, costKeys); ok {
+			cost = c
+		}
+		w.open("TRANSFER")
+		invtran(id, date)
+		w.elem("SUBACCTSEC", "CASH")
+		w.elem("UNITS", units.String())
+		w.elem("TFERACTION", "IN")
+		w.elem("POSTYPE", "LONG")
+		w.elem("AVGCOSTBASIS", units.Mul(cost).Fixed(2))
+		w.elem("UNITPRICE", cost.Fixed(4))
+		w.close("TRANSFER")
+	}
+
+	switch {
+	case ev.Type == "Vest" || ev.Type == "Transfer" || derActions[e["Action"]]:
+		if !haveUnits {
+			return false, nil
+		}
+		transferIn(id, date)
+
+	case ev.Type == "Exercise" || ev.Type == "Buy":
+		if !haveUnits {
+			return false, nil
+		}
+		if ev.Type == "Buy" && ev.Price == "" {
+			if c, ok := first(e, costKeys); ok {
+				price = c
+			}
+		}
+		w.open("BUYSTOCK")
+		w.open("INVBUY")
+		invtran(id, date)
+		w.elem("UNITS", units.String())
+		w.elem("UNITPRICE", price.Fixed(4))
+		w.elem("COMMISSION", fees.Fixed(2))
+		w.elem("TOTAL", units.Mul(price).Add(fees).Neg().Fixed(2))
+		w.elem("SUBACCTSEC", "CASH")
+		w.elem("SUBACCTFUND", "CASH")
+		w.close("INVBUY")
+		w.elem("BUYTYPE", "BUY")
+		w.close("BUYSTOCK")
+
+	case ev.Type == "Sale" || ev.Type == "TaxSale":
+		if !haveUnits {
+			return false, nil
+		}
+		if !haveCash {
+			cash = units.Mul(price).Sub(fees).Sub(taxes)
+		}
+		if saleActions[e["Action"]] {
+			// The shares sold are acquired at the same time.
+			acquired := date
+			if d, err := parseDate(e["Purchase Date"]); err == nil {
+				acquired = d
+			}
+			transferIn(e["ID"]+"-in", acquired)
+		}
+		w.open("SELLSTOCK")
+		w.open("INVSELL")
+		invtran(id, date)
+		w.elem("UNITS", units.Neg().String())
+		w.elem("UNITPRICE", price.Fixed(4))
+		w.elem("COMMISSION", fees.Fixed(2))
+		w.elem("TAXES", taxes.Fixed(2))
+		w.elem("TOTAL", cash.Fixed(2))
+		w.elem("SUBACCTSEC", "CASH")
+		w.elem("SUBACCTFUND", "CASH")
+		w.close("INVSELL")
+		w.elem("SELLTYPE", "SELL")
+		w.close("SELLSTOCK")
+
+	case ev.Type == "Dividend" && haveUnits:
+		w.open("REINVEST")
+		invtran(id, date)
+		w.elem("INCOMETYPE", "DIV")
+		w.elem("TOTAL", units.Mul(price).Neg().Fixed(2))
+		w.elem("SUBACCTSEC", "CASH")
+		w.elem("UNITS", units.String())
+		w.elem("UNITPRICE", price.Fixed(4))
+		w.elem("TAXES", taxes.Fixed(2))
+		w.close("REINVEST")
+
+	case ev.Type == "Dividend" && haveCash:
+		w.open("INCOME")
+		invtran(id, date)
+		w.elem("INCOMETYPE", "DIV")
+		w.elem("TOTAL", cash.Fixed(2))
+		w.elem("SUBACCTSEC", "CASH")
+		w.elem("SUBACCTFUND", "CASH")
+		w.elem("WITHHOLDING", taxes.Fixed(2))
+		w.close("INCOME")
+
+	default:
+		return false, nil
+	}
+	return true, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/net/html"
+)
+
+// The columns of the options summary, as variously labelled, and
+// the keys they are given as.
+var optionKeys = map[string]string{
+	"Award ID":              "Award ID",
+	"Award Number":          "Award ID",
+	"Grant ID":              "Award ID",
+	"Grant Id":              "Award ID",
+	"Grant Number":          "Award ID",
+	"Award Date":            "Award Date",
+	"Grant Date":            "Award Date",
+	"Award Type":            "Type",
+	"Grant Type":            "Type",
+	"Type":                  "Type",
+	"Symbol":                "Symbol",
+	"Award Price":           "Award Price",
+	"Grant Price":           "Award Price",
+	"Strike Price":          "Award Price",
+	"Exercise Price":        "Award Price",
+	"Granted":               "Shares",
+	"Shares Granted":        "Shares",
+	"Outstanding":           "Shares",
+	"Vested":                "Vested",
+	"Vested Shares":         "Vested",
+	"Exercisable":           "Exercisable",
+	"Exercisable Shares":    "Exercisable",
+	"Available to Exercise": "Exercisable",
+	"Expiration Date":       "Expiration Date",
+	"Expiration":            "Expiration Date",
+	"Expires":               "Expiration Date",
+}
+
+// Options expiring within this many days are reported as expiring.
+const expiringDays = 90
+
+// Parse the options summary page: a table of the option grants,
+// with their strike prices, the shares vested and exercisable, and
+// when they expire. The result is an entry for each grant, marked
+// with the "Record" "option", or nil if the page isn't one.
+func parseOptions(doc *html.Node) []map[string]string {
+	var entries []map[string]string
+	for _, rows := range headedTables(doc, optionKeys) {
+		for _, e := range rows {
+			if e["Expiration Date"] == "" || e["Award Price"] == "" {
+				continue
+			}
+			e["Record"] = "option"
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// Report on the options parsed from options summaries among the
+// files: the in-the-money value of their exercisable shares at
+// -price, if given, and the days left until they expire, as of
+// -date (by default, today). Those expiring within expiringDays
+// are marked.
+func optionsCommand(args []string) error {
+	q, err := parseQuery(*queryFlag)
+	if err != nil {
+		return err
+	}
+	date, err := flagDate()
+	if err != nil {
+		return err
+	}
+	var price Decimal
+	havePrice := *priceFlag != ""
+	if havePrice {
+		if price, err = parseDecimal(*priceFlag); err != nil {
+			return err
+		}
+	}
+	entries, err := load(args)
+	if err != nil {
+		return err
+	}
+
+	var records []map[string]string
+	for _, e := range entries {
+		if e["Record"] != "option" {
+			continue
+		}
+		r := make(map[string]string)
+		for _, k := range []string{"Award ID", "Award Date", "Type", "Symbol", "Award Price", "Exercisable", "Expiration Date"} {
+			r[k] = e[k]
+		}
+		if exp, err := parseDate(e["Expiration Date"]); err == nil {
+			days := int(exp.Sub(date).Hours() / 24)
+			r["Days to Expiration"] = fmt.Sprint(days)
+			if days >= 0 && days <= expiringDays {
+				r["Expiring"] = "yes"
+			}
+		}
+		strike, ok1 := amount(e, "Award Price")
+		shares, ok2 := amount(e, "Exercisable")
+		if havePrice && ok1 && ok2 {
+			value := price.Sub(strike).Mul(shares)
+			if value.Sign() < 0 {
+				value = Decimal{}
+			}
+			r["In the Money"] = value.Fixed(2)
+		}
+		records = append(records, r)
+	}
+	return emit(os.Stdout, records, q)
+}
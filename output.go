@@ -0,0 +1,401 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+)
+
+// The keys selected by -fields, or nil if all keys are emitted.
+func fields() []string {
+	if *fieldsFlag == "" {
+		return nil
+	}
+	var keys []string
+	for _, k := range strings.Split(*fieldsFlag, ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// Select the entries given by -offset and -limit.
+func window(entries []map[string]string) []map[string]string {
+	off := *offsetFlag
+	if off < 0 {
+		off += len(entries)
+		if off < 0 {
+			off = 0
+		}
+	}
+	if off > len(entries) {
+		off = len(entries)
+	}
+	entries = entries[off:]
+
+	if n := *limitFlag; n > 0 && n < len(entries) {
+		entries = entries[:n]
+	}
+	return entries
+}
+
+// The location in which dates are anchored, from -timezone; if
+// nil, dates are emitted as they are.
+var location *time.Location
+
+// Anchor a date value at midnight in location, as an RFC 3339
+// timestamp. Values of keys that aren't dates are returned as is.
+func anchor(k, v string) string {
+	if location == nil || !strings.Contains(k, "Date") && !strings.Contains(k, "Since") {
+		return v
+	}
+	t, err := parseDate(v)
+	if err != nil {
+		return v
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, location).Format(time.RFC3339)
+}
+
+// Render entries for output. If -fields is given, only the
+// selected keys are kept; selected keys missing from an entry are
+// treated as empty. Empty values are represented according to
+// -empty: as empty strings, omitted, or as nulls. With -timezone,
+// dates are given as timestamps (see anchor).
+func render(entries []map[string]string) []interface{} {
+	keys := fields()
+	out := make([]interface{}, len(entries))
+	for i, e := range entries {
+		if keys != nil {
+			p := make(map[string]string)
+			for _, k := range keys {
+				p[k] = e[k]
+			}
+			e = p
+		}
+
+		m := make(map[string]interface{})
+		for k, v := range e {
+			switch {
+			case v != "" || *emptyFlag == "string":
+				m[k] = anchor(k, v)
+			case *emptyFlag == "null":
+				m[k] = nil
+			}
+		}
+		out[i] = m
+	}
+	return out
+}
+
+// Render entries as render does, but as text, for -format csv,
+// tsv, or table, where empty values are empty however given.
+func renderText(entries []map[string]string) []map[string]string {
+	out := make([]map[string]string, len(entries))
+	for i, r := range render(entries) {
+		out[i] = stringMap(r.(map[string]interface{}))
+	}
+	return out
+}
+
+// Write entries to w as JSON, after selecting and rendering
+// them (with -coalesce-vests, as vests; see coalesceVests) and
+// applying the query. With -envelope, the output is wrapped with
+// its schema version.
+func emit(w io.Writer, entries []map[string]string, q query) error {
+	var v []interface{}
+	if *coalesceFlag {
+		v = coalesceVests(window(entries))
+	} else {
+		v = render(window(entries))
+	}
+	return encode(w, v, "", q)
+}
+
+// Write the entries of a conversion to w as emit does, but
+// modelled according to -model (see modelEvents) or -group-by (see
+// groupByAward). The query applies to the output as written.
+func emitEntries(w io.Writer, entries []map[string]string, q query) error {
+	var (
+		v      interface{}
+		schema string
+	)
+	switch {
+	case *groupFlag == "award":
+		v = groupByAward(window(entries))
+	case *modelFlag == "events":
+		v, schema = modelEvents(window(entries)), eventsSchema
+	default:
+		return emit(w, entries, q)
+	}
+	if len(q) > 0 {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(b, &v); err != nil {
+			return err
+		}
+	}
+	return encode(w, v, schema, q)
+}
+
+// Write v to w as JSON after applying the query, wrapped, with
+// -envelope, with its schema.
+func encode(w io.Writer, v interface{}, schema string, q query) error {
+	out, err := q.Eval(v)
+	if err != nil {
+		return err
+	}
+	if *envelopeFlag {
+		out = envelope{SchemaVersion: schemaVersion, Schema: schema, Entries: out}
+	}
+	b := bufio.NewWriter(w)
+	if err := json.NewEncoder(b).Encode(out); err != nil {
+		return err
+	}
+	return b.Flush()
+}
+
+// Emit the entries for each symbol into its own file, SYMBOL.json.
+// Entries without a symbol go into other.json.
+func split(entries []map[string]string, q query) error {
+	var (
+		syms     []string
+		bySymbol = make(map[string][]map[string]string)
+	)
+	for _, e := range entries {
+		sym := e["Symbol"]
+		if _, ok := bySymbol[sym]; !ok {
+			syms = append(syms, sym)
+		}
+		bySymbol[sym] = append(bySymbol[sym], e)
+	}
+
+	for _, sym := range syms {
+		name := strings.Map(func(c rune) rune {
+			if c == '/' || c == '\\' || c == os.PathSeparator {
+				return '_'
+			}
+			return c
+		}, sym)
+		if name == "" {
+			name = "other"
+		}
+
+		if recipients == nil {
+			f, err := os.Create(name + ".json")
+			if err != nil {
+				return err
+			}
+			if err := emitEntries(f, bySymbol[sym], q); err != nil {
+				f.Close()
+				return err
+			}
+			if err := f.Close(); err != nil {
+				return err
+			}
+			continue
+		}
+		var b bytes.Buffer
+		if err := emitEntries(&b, bySymbol[sym], q); err != nil {
+			return err
+		}
+		data, err := encrypt(b.Bytes())
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(name+".json.age", data, 0666); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Write records as CSV with the given columns, in the dialect given
+// by -csv-delimiter, -csv-quote-all, and -csv-crlf.
+func writeCSV(w io.Writer, columns []string, records []map[string]string) error {
+	comma := csvDelimiter()
+	if *csvQuoteAllFlag {
+		return writeQuotedCSV(w, comma, columns, records)
+	}
+	cw := csv.NewWriter(w)
+	cw.Comma = comma
+	cw.UseCRLF = *csvCRLFFlag
+	cw.Write(columns)
+	for _, r := range records {
+		row := make([]string, len(columns))
+		for i, c := range columns {
+			row[i] = r[c]
+		}
+		cw.Write(row)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// The delimiter given by -csv-delimiter, of which "\t" and "tab"
+// are tabs, or 0 if it isn't a single character.
+func csvDelimiter() rune {
+	switch *csvDelimiterFlag {
+	case `\t`, "tab":
+		return '\t'
+	}
+	if utf8.RuneCountInString(*csvDelimiterFlag) != 1 {
+		return 0
+	}
+	r, _ := utf8.DecodeRuneInString(*csvDelimiterFlag)
+	return r
+}
+
+// Write CSV with every field quoted, as encoding/csv won't.
+func writeQuotedCSV(w io.Writer, comma rune, columns []string, records []map[string]string) error {
+	bw := bufio.NewWriter(w)
+	eol := "\n"
+	if *csvCRLFFlag {
+		eol = "\r\n"
+	}
+	row := func(fields []string) {
+		for i, f := range fields {
+			if i > 0 {
+				bw.WriteRune(comma)
+			}
+			bw.WriteString(`"` + strings.Replace(f, `"`, `""`, -1) + `"`)
+		}
+		bw.WriteString(eol)
+	}
+	row(columns)
+	for _, r := range records {
+		fields := make([]string, len(columns))
+		for i, c := range columns {
+			fields[i] = r[c]
+		}
+		row(fields)
+	}
+	return bw.Flush()
+}
+
+// Write records as tab-separated values with the given columns, for
+// awk and cut: nothing is quoted, so tabs and newlines in values are
+// given as spaces.
+func writeTSV(w io.Writer, columns []string, records []map[string]string) error {
+	bw := bufio.NewWriter(w)
+	clean := strings.NewReplacer("\t", " ", "\r", " ", "\n", " ")
+	row := func(fields []string) {
+		for i, f := range fields {
+			if i > 0 {
+				bw.WriteByte('\t')
+			}
+			bw.WriteString(clean.Replace(f))
+		}
+		bw.WriteByte('\n')
+	}
+	row(columns)
+	for _, r := range records {
+		fields := make([]string, len(columns))
+		for i, c := range columns {
+			fields[i] = r[c]
+		}
+		row(fields)
+	}
+	return bw.Flush()
+}
+
+// Write records as an aligned table with the given columns.
+func writeTable(w io.Writer, columns []string, records []map[string]string) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	io.WriteString(tw, strings.Join(columns, "\t")+"\n")
+	for _, r := range records {
+		row := make([]string, len(columns))
+		for i, c := range columns {
+			row[i] = r[c]
+		}
+		io.WriteString(tw, strings.Join(row, "\t")+"\n")
+	}
+	return tw.Flush()
+}
+
+// Write the records of a report as -format: JSON (as emit), or CSV,
+// TSV, or a table with the given columns.
+func report(w io.Writer, columns []string, records []map[string]string, q query) error {
+	switch *formatFlag {
+	case "csv":
+		return writeCSV(w, columns, records)
+	case "tsv":
+		return writeTSV(w, columns, records)
+	case "table":
+		return writeTable(w, columns, records)
+	}
+	return emit(w, records, q)
+}
+
+// With -encrypt or -sign, standard output is diverted while the
+// command runs, so that the output can be encrypted and then signed
+// as a whole. It is kept in memory, so that no plain text is left
+// behind.
+type diversion struct {
+	out  *os.File
+	w    *os.File
+	buf  bytes.Buffer
+	done chan error
+}
+
+func divert() (*diversion, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	d := &diversion{out: os.Stdout, w: w, done: make(chan error, 1)}
+	go func() {
+		_, err := io.Copy(&d.buf, r)
+		r.Close()
+		d.done <- err
+	}()
+	os.Stdout = w
+	return d, nil
+}
+
+// Restore standard output, returning what was written to it.
+func (d *diversion) restore() ([]byte, error) {
+	os.Stdout = d.out
+	d.w.Close()
+	if err := <-d.done; err != nil {
+		return nil, err
+	}
+	return d.buf.Bytes(), nil
+}
+
+// Restore standard output and discard the diverted output, as when
+// the command fails.
+func (d *diversion) Abort() {
+	d.restore()
+}
+
+// Restore standard output, writing the diverted output to it,
+// encrypted and signed as requested.
+func (d *diversion) Done() error {
+	data, err := d.restore()
+	if err != nil {
+		return err
+	}
+	if recipients != nil {
+		if data, err = encrypt(data); err != nil {
+			return err
+		}
+	}
+	if _, err := d.out.Write(data); err != nil {
+		return err
+	}
+	if *signFlag != "" {
+		return writeSignature(data)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// Resolve transactions given by more than one of the files, as
+// when exports overlap, by -prefer: those of the file given last
+// ("newest"), or first ("oldest"), or fail ("error") if they differ.
+// Transactions are matched by their natural keys (see naturalKey);
+// all the entries of a file with a key are taken together. What is
+// chosen for differing transactions is logged; identical ones are
+// simply kept once. Each entry is taken to be from the file from
+// gives for it, an index into files, whatever its "Source" says:
+// archived entries keep the source they were first converted from.
+func resolveOverlaps(entries []map[string]string, files []string, from []int) ([]map[string]string, error) {
+	// The indices of the entries of each key, by file.
+	groups := make(map[string]map[int][]int)
+	for i, e := range entries {
+		if e["Date"] == "" || e["Action"] == "" {
+			continue
+		}
+		k := naturalKey(e)
+		if groups[k] == nil {
+			groups[k] = make(map[int][]int)
+		}
+		groups[k][from[i]] = append(groups[k][from[i]], i)
+	}
+	var keys []string
+	for k, g := range groups {
+		if len(g) > 1 {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	contents := func(indices []int) string {
+		var parts []string
+		for _, i := range indices {
+			e := entries[i]
+			var keys []string
+			for k := range e {
+				if !derivedKeys[k] {
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				parts = append(parts, k+"\x00"+e[k])
+			}
+			parts = append(parts, "\x01")
+		}
+		return strings.Join(parts, "\x00")
+	}
+
+	drop := make(map[int]bool)
+	for _, k := range keys {
+		g := groups[k]
+		var sources []int
+		for s := range g {
+			sources = append(sources, s)
+		}
+		sort.Ints(sources)
+		var names []string
+		for _, s := range sources {
+			names = append(names, files[s])
+		}
+
+		keep := sources[len(sources)-1]
+		if *preferFlag == "oldest" {
+			keep = sources[0]
+		}
+		for _, s := range sources {
+			if contents(g[s]) == contents(g[keep]) {
+				continue
+			}
+			e := entries[g[keep][0]]
+			what := fmt.Sprintf("%s %s %s", e["Date"], e["Action"], e["Symbol"])
+			if *preferFlag == "error" {
+				return nil, fmt.Errorf("%s differs between %s", what, strings.Join(names, " and "))
+			}
+			log.Printf("%s differs between %s; taking %s's", what, strings.Join(names, " and "), files[keep])
+			break
+		}
+		for _, s := range sources {
+			if s != keep {
+				for _, i := range g[s] {
+					drop[i] = true
+				}
+			}
+		}
+	}
+	if len(drop) == 0 {
+		return entries, nil
+	}
+	var kept []map[string]string
+	for i, e := range entries {
+		if !drop[i] {
+			kept = append(kept, e)
+		}
+	}
+	return kept, nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+)
+
+// Keys that tag entries with where they came from, rather than
+// describe the transaction.
+var derivedKeys = map[string]bool{
+	"ID":           true,
+	"Seq":          true,
+	"Source":       true,
+	"Source Row":   true,
+	"Account Name": true,
+}
+
+// Give each entry a synthetic "ID", unless it already has one (e.g.
+// from an earlier run). The ID is a hash of the entry's contents,
+// less its derived keys, so it is stable across runs and doesn't
+// depend on how the files were named or tagged; identical entries
+// are numbered in order of appearance.
+func identify(entries []map[string]string) {
+	seen := make(map[string]int)
+	for _, e := range entries {
+		if e["ID"] != "" {
+			continue
+		}
+		var keys []string
+		for k := range e {
+			if !derivedKeys[k] {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		h := sha1.New()
+		for _, k := range keys {
+			fmt.Fprintf(h, "%s\x00%s\x00", k, e[k])
+		}
+		id := hex.EncodeToString(h.Sum(nil))[:12]
+		if n := seen[id]; n > 0 {
+			seen[id]++
+			e["ID"] = fmt.Sprintf("%s-%d", id, n+1)
+		} else {
+			seen[id] = 1
+			e["ID"] = id
+		}
+	}
+}
+
+// An overlay holds manual corrections to entries, by ID: keys to
+// set (e.g. a fixed "Fair Market Value", or a "Note"), and whether
+// to "Exclude" the entry altogether.
+type overlay map[string]map[string]string
+
+// Read an overlay from a JSON object of ID to key-values, or from
+// a CSV file with an "ID" column; empty CSV cells are ignored.
+func readOverlay(file string) (overlay, error) {
+	f, err := openInput(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	o := make(overlay)
+	if strings.HasSuffix(strings.ToLower(file), ".json") {
+		var raw map[string]map[string]interface{}
+		if err := json.NewDecoder(f).Decode(&raw); err != nil {
+			return nil, fmt.Errorf("%s: %s", file, err)
+		}
+		for id, m := range raw {
+			o[id] = stringMap(m)
+		}
+		return o, nil
+	}
+
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("%s: no header", file)
+	}
+	header := rows[0]
+	id := -1
+	for i, h := range header {
+		header[i] = strings.TrimSpace(h)
+		if header[i] == "ID" {
+			id = i
+		}
+	}
+	if id < 0 {
+		return nil, fmt.Errorf("%s: no ID column", file)
+	}
+	for _, row := range rows[1:] {
+		if strings.TrimSpace(row[id]) == "" {
+			continue
+		}
+		m := make(map[string]string)
+		for i, v := range row {
+			if v = strings.TrimSpace(v); i != id && i < len(header) && v != "" {
+				m[header[i]] = v
+			}
+		}
+		o[strings.TrimSpace(row[id])] = m
+	}
+	return o, nil
+}
+
+// Apply the overlay to the entries, dropping the excluded ones.
+// Corrections for IDs that aren't among the entries are reported.
+func (o overlay) Apply(entries []map[string]string) []map[string]string {
+	var (
+		out  []map[string]string
+		used = make(map[string]bool)
+	)
+	for _, e := range entries {
+		m, ok := o[e["ID"]]
+		if !ok {
+			out = append(out, e)
+			continue
+		}
+		used[e["ID"]] = true
+		if exclude(m["Exclude"]) {
+			continue
+		}
+		for k, v := range m {
+			if k != "Exclude" && k != "ID" {
+				e[k] = v
+			}
+		}
+		out = append(out, e)
+	}
+
+	var unused []string
+	for id := range o {
+		if !used[id] {
+			unused = append(unused, id)
+		}
+	}
+	sort.Strings(unused)
+	for _, id := range unused {
+		log.Printf("no entry with ID %s", id)
+	}
+	return out
+}
+
+// Read a list of IDs to exclude, one per line, as an overlay.
+// Blank lines and lines starting with # are ignored.
+func readExcludes(file string) (overlay, error) {
+	f, err := openInput(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	o := make(overlay)
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		o[line] = map[string]string{"Exclude": "true"}
+	}
+	return o, nil
+}
+
+func exclude(v string) bool {
+	switch strings.ToLower(v) {
+	case "true", "yes", "y", "1", "x":
+		return true
+	}
+	return false
+}
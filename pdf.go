@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// The actions found on statements, longest first so that the
+// pattern prefers them.
+var statementActions = []string{
+	"Dividend Reinvestment",
+	"Forced Disbursement",
+	"Forced Quick Sell",
+	"Exer and Hold",
+	"Sell to Cover",
+	"Quick Sell",
+	"Dividend",
+	"Deposit",
+	"Journal",
+	"Release",
+	"Lapse",
+	"Sale",
+}
+
+var (
+	statementLine = regexp.MustCompile(`^\s*(\d\d/\d\d/\d{4})\s+(` +
+		strings.Join(statementActions, "|") + `)\b\s*(.*)$`)
+	columnSep = regexp.MustCompile(`\s{2,}`)
+)
+
+// Parse the transactions in the text of an EAC monthly or annual
+// statement, as extracted from its PDF with pdftotext -layout.
+// Transaction lines begin with a date and an action, followed by
+// columns separated by runs of spaces: the symbol, the quantity,
+// the price, and the amount. Other lines are ignored.
+func parseStatement(r io.Reader) ([]map[string]string, error) {
+	var entries []map[string]string
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		m := statementLine.FindStringSubmatch(sc.Text())
+		if m == nil {
+			continue
+		}
+		e := map[string]string{
+			"Date":   m[1],
+			"Action": m[2],
+		}
+
+		var amounts []string
+		for _, col := range columnSep.Split(strings.TrimSpace(m[3]), -1) {
+			switch {
+			case col == "":
+			case strings.ContainsAny(col, "$("):
+				amounts = append(amounts, col)
+			case e["Quantity"] == "" && isNumber(col):
+				e["Quantity"] = col
+			case e["Symbol"] == "" && !isNumber(col):
+				e["Symbol"] = col
+			}
+		}
+		switch len(amounts) {
+		case 0:
+		case 1:
+			e["Amount"] = amounts[0]
+		default:
+			e["Price"] = amounts[0]
+			e["Amount"] = amounts[len(amounts)-1]
+		}
+		if e["Price"] != "" && (eacSales[e["Action"]] || e["Action"] == "Sale") {
+			e["Sale Price"] = e["Price"]
+		}
+		entries = append(entries, e)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, errors.New("no transactions in statement")
+	}
+	return entries, nil
+}
+
+func isNumber(s string) bool {
+	_, err := parseDecimal(s)
+	return err == nil
+}
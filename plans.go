@@ -0,0 +1,434 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+// A plan is a plan administrator's transaction export, in CSV. It
+// is recognized by its header, which has all the columns in sign,
+// and its rows are normalized to our entries: columns are renamed
+// by columns (others are kept as labelled), and transaction types
+// become actions by actions (others are kept as given). Entries
+// are tagged with the plan's name as their "Administrator".
+type plan struct {
+	name    string
+	sign    []string
+	columns map[string]string
+	actions map[string]planAction
+}
+
+// The action a transaction type amounts to, and the award type of
+// the shares it involves, if it implies one.
+type planAction struct {
+	action, typ string
+}
+
+// The plans known, in the order they are sniffed.
+var plans = []*plan{
+	computershare,
+	merrill,
+	ubs,
+	carta,
+	shareworks,
+}
+
+// Computershare administers many older ESPPs. Purchased shares
+// are held in the plan account, and sold from there, so purchases
+// are taken as buys, like those of a brokerage history.
+var computershare = &plan{
+	name: "computershare",
+	sign: []string{"transaction type", "share balance"},
+	columns: map[string]string{
+		"transaction date":        "Date",
+		"date":                    "Date",
+		"transaction type":        "Transaction Type",
+		"transaction description": "Description",
+		"description":             "Description",
+		"ticker":                  "Symbol",
+		"symbol":                  "Symbol",
+		"plan":                    "Plan",
+		"plan name":               "Plan",
+		"number of shares":        "Shares",
+		"shares":                  "Shares",
+		"share price":             "Price",
+		"price per share":         "Price",
+		"transaction amount":      "Amount",
+		"amount":                  "Amount",
+		"fees":                    "Fees & Commissions",
+		"share balance":           "Share Balance",
+		"offering date":           "Subscription Date",
+		"offering period start":   "Subscription Date",
+		"offering fmv":            "Subscription FMV",
+		"purchase date":           "Purchase Date",
+		"purchase price":          "Purchase Price",
+		"purchase fmv":            "Purchase FMV",
+		"fmv on purchase date":    "Purchase FMV",
+	},
+	actions: map[string]planAction{
+		"purchase":              {"Buy", "ESPP"},
+		"espp purchase":         {"Buy", "ESPP"},
+		"employee purchase":     {"Buy", "ESPP"},
+		"payroll purchase":      {"Buy", "ESPP"},
+		"sale":                  {"Sell", ""},
+		"sell":                  {"Sell", ""},
+		"sold":                  {"Sell", ""},
+		"dividend":              {"Dividend", ""},
+		"cash dividend":         {"Dividend", ""},
+		"dividend reinvestment": {"Dividend Reinvestment", ""},
+		"reinvestment":          {"Dividend Reinvestment", ""},
+		"transfer":              {"Journal", ""},
+		"transfer out":          {"Journal", ""},
+		"drs transfer":          {"Journal", ""},
+	},
+}
+
+// Merrill's Benefits Online exports equity award activity with a
+// row for each vest, exercise, purchase and sale. Vests are given
+// gross and net of the shares withheld, as lapses are; shares are
+// held, and sold, in the Merrill brokerage account.
+var merrill = &plan{
+	name: "merrill",
+	sign: []string{"activity", "grant number"},
+	columns: map[string]string{
+		"activity date":        "Date",
+		"date":                 "Date",
+		"activity":             "Transaction Type",
+		"description":          "Description",
+		"symbol":               "Symbol",
+		"grant number":         "Award ID",
+		"grant date":           "Award Date",
+		"grant type":           "Type",
+		"award type":           "Type",
+		"grant price":          "Award Price",
+		"exercise price":       "Award Price",
+		"shares":               "Shares",
+		"gross shares":         "Quantity",
+		"shares vested":        "Quantity",
+		"shares withheld":      "Shares Sold/Withheld for Taxes",
+		"net shares":           "Net Shares Deposited",
+		"fmv":                  "Fair Market Value",
+		"fair market value":    "Fair Market Value",
+		"market value":         "Fair Market Value",
+		"sale price":           "Sale Price",
+		"price":                "Price",
+		"gross proceeds":       "Amount",
+		"net proceeds":         "Net Proceeds",
+		"taxes withheld":       "Taxes",
+		"commissions and fees": "Fees & Commissions",
+		"fees":                 "Fees & Commissions",
+		"offering date":        "Subscription Date",
+		"offering date fmv":    "Subscription FMV",
+		"purchase date":        "Purchase Date",
+		"purchase price":       "Purchase Price",
+		"purchase date fmv":    "Purchase FMV",
+	},
+	actions: map[string]planAction{
+		"release":               {"Release", ""},
+		"vest":                  {"Release", ""},
+		"shares released":       {"Release", ""},
+		"rsu release":           {"Release", ""},
+		"exercise and hold":     {"Exer and Hold", ""},
+		"exercise & hold":       {"Exer and Hold", ""},
+		"exercise and sell":     {"Sale", ""},
+		"exercise & sell":       {"Sale", ""},
+		"same day sale":         {"Sale", ""},
+		"cashless exercise":     {"Sale", ""},
+		"espp purchase":         {"Buy", "ESPP"},
+		"purchase":              {"Buy", "ESPP"},
+		"sale":                  {"Sell", ""},
+		"sell":                  {"Sell", ""},
+		"dividend":              {"Dividend", ""},
+		"dividend reinvestment": {"Dividend Reinvestment", ""},
+		"forfeiture":            {"Forfeiture", ""},
+		"cancellation":          {"Cancellation", ""},
+		"expiration":            {"Expiration", ""},
+		"transfer":              {"Journal", ""},
+	},
+}
+
+// UBS One Source exports plan activity by "Transaction Type", with
+// the grant as a "Grant ID". Shares delivered on vesting are net of
+// those withheld. An option's "Lapse" is its expiration.
+var ubs = &plan{
+	name: "ubs",
+	sign: []string{"transaction type", "grant id"},
+	columns: map[string]string{
+		"transaction date":        "Date",
+		"date":                    "Date",
+		"transaction type":        "Transaction Type",
+		"transaction description": "Description",
+		"description":             "Description",
+		"symbol":                  "Symbol",
+		"plan":                    "Plan",
+		"plan type":               "Type",
+		"grant id":                "Award ID",
+		"grant date":              "Award Date",
+		"grant price":             "Award Price",
+		"strike price":            "Award Price",
+		"quantity":                "Quantity",
+		"shares":                  "Shares",
+		"shares delivered":        "Net Shares Deposited",
+		"shares withheld for tax": "Shares Sold/Withheld for Taxes",
+		"market price":            "Fair Market Value",
+		"fair market value":       "Fair Market Value",
+		"sale price":              "Sale Price",
+		"price":                   "Price",
+		"gross amount":            "Amount",
+		"net amount":              "Net Proceeds",
+		"tax withheld":            "Taxes",
+		"fees":                    "Fees & Commissions",
+		"offering start date":     "Subscription Date",
+		"offering start fmv":      "Subscription FMV",
+		"purchase date":           "Purchase Date",
+		"purchase price":          "Purchase Price",
+		"purchase fmv":            "Purchase FMV",
+	},
+	actions: map[string]planAction{
+		"vesting":               {"Release", ""},
+		"vest":                  {"Release", ""},
+		"share delivery":        {"Release", ""},
+		"exercise and hold":     {"Exer and Hold", ""},
+		"exercise - hold":       {"Exer and Hold", ""},
+		"exercise and sell":     {"Sale", ""},
+		"exercise - sell":       {"Sale", ""},
+		"cashless exercise":     {"Sale", ""},
+		"espp purchase":         {"Buy", "ESPP"},
+		"purchase":              {"Buy", "ESPP"},
+		"sale":                  {"Sell", ""},
+		"sell":                  {"Sell", ""},
+		"dividend":              {"Dividend", ""},
+		"dividend reinvestment": {"Dividend Reinvestment", ""},
+		"forfeiture":            {"Forfeiture", ""},
+		"lapse":                 {"Expiration", ""},
+		"expiry":                {"Expiration", ""},
+		"transfer":              {"Journal", ""},
+		"transfer out":          {"Journal", ""},
+	},
+}
+
+// Carta and Shareworks hold the equity of private companies, whose
+// shares have no symbol; the company (or its share class) stands
+// in for one. Their fair market values are 409A valuations. Shares
+// are held on the company's books, so are taken as in a brokerage
+// account.
+var carta = &plan{
+	name: "carta",
+	sign: []string{"event", "security"},
+	columns: map[string]string{
+		"date":              "Date",
+		"event date":        "Date",
+		"event":             "Transaction Type",
+		"description":       "Description",
+		"company":           "Symbol",
+		"issuer":            "Symbol",
+		"security":          "Award ID",
+		"grant date":        "Award Date",
+		"issue date":        "Award Date",
+		"security type":     "Type",
+		"exercise price":    "Award Price",
+		"quantity":          "Shares",
+		"shares":            "Shares",
+		"shares settled":    "Quantity",
+		"shares withheld":   "Shares Sold/Withheld for Taxes",
+		"net shares":        "Net Shares Deposited",
+		"fmv":               "Fair Market Value",
+		"fmv at exercise":   "Fair Market Value",
+		"fmv at settlement": "Fair Market Value",
+		"409a price":        "Fair Market Value",
+		"price":             "Price",
+		"sale price":        "Sale Price",
+		"proceeds":          "Amount",
+		"taxes withheld":    "Taxes",
+	},
+	actions: map[string]planAction{
+		"exercise":          {"Exer and Hold", ""},
+		"option exercise":   {"Exer and Hold", ""},
+		"early exercise":    {"Exer and Hold", ""},
+		"rsu settlement":    {"Release", "RSU"},
+		"settlement":        {"Release", "RSU"},
+		"tender offer sale": {"Sell", ""},
+		"secondary sale":    {"Sell", ""},
+		"sale":              {"Sell", ""},
+		"transfer":          {"Journal", ""},
+		"cancellation":      {"Cancellation", ""},
+		"forfeiture":        {"Forfeiture", ""},
+		"expiration":        {"Expiration", ""},
+	},
+}
+
+// Shareworks, likewise.
+var shareworks = &plan{
+	name: "shareworks",
+	sign: []string{"transaction", "grant name"},
+	columns: map[string]string{
+		"transaction date":  "Date",
+		"date":              "Date",
+		"transaction":       "Transaction Type",
+		"description":       "Description",
+		"company":           "Symbol",
+		"symbol":            "Symbol",
+		"grant name":        "Award ID",
+		"grant date":        "Award Date",
+		"award type":        "Type",
+		"exercise price":    "Award Price",
+		"quantity":          "Shares",
+		"gross shares":      "Quantity",
+		"shares withheld":   "Shares Sold/Withheld for Taxes",
+		"net shares":        "Net Shares Deposited",
+		"fair market value": "Fair Market Value",
+		"fmv":               "Fair Market Value",
+		"price":             "Price",
+		"sale price":        "Sale Price",
+		"gross proceeds":    "Amount",
+		"taxes withheld":    "Taxes",
+	},
+	actions: map[string]planAction{
+		"exercise":             {"Exer and Hold", ""},
+		"exercise and hold":    {"Exer and Hold", ""},
+		"exercise and sell":    {"Sale", ""},
+		"release":              {"Release", ""},
+		"rsu release":          {"Release", "RSU"},
+		"settlement":           {"Release", "RSU"},
+		"sale":                 {"Sell", ""},
+		"liquidity event sale": {"Sell", ""},
+		"transfer":             {"Journal", ""},
+		"cancellation":         {"Cancellation", ""},
+		"forfeiture":           {"Forfeiture", ""},
+		"expiration":           {"Expiration", ""},
+	},
+}
+
+// Administrators write dates in several ways; they're made ours.
+var planDates = []string{"01/02/2006", "1/2/2006", "2006-01-02", "02-Jan-2006", "2-Jan-2006", "Jan 2, 2006", "02 Jan 2006"}
+
+// Find the plan whose header is among the lines of head.
+func sniffPlan(head string) *plan {
+	for _, line := range strings.Split(strings.ToLower(head), "\n") {
+		cols := make(map[string]bool)
+		for _, c := range strings.Split(line, ",") {
+			cols[strings.Trim(strings.TrimSpace(c), `"`)] = true
+		}
+		for _, p := range plans {
+			if p.matches(cols) {
+				return p
+			}
+		}
+	}
+	return nil
+}
+
+func planNamed(name string) *plan {
+	for _, p := range plans {
+		if p.name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+func (p *plan) matches(cols map[string]bool) bool {
+	for _, s := range p.sign {
+		if !cols[s] {
+			return false
+		}
+	}
+	return true
+}
+
+// Parse the plan's export. Lines before the header (titles, account
+// numbers) are skipped, as are rows without a date, such as totals.
+// Share counts are given without sign; the action says which way
+// they go. The type as given is kept as the "Reported Action".
+func (p *plan) Parse(r io.Reader) ([]map[string]string, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	rows, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	var header []string
+	for len(rows) > 0 && header == nil {
+		cols := make(map[string]bool)
+		for _, c := range rows[0] {
+			cols[strings.ToLower(strings.TrimSpace(c))] = true
+		}
+		if p.matches(cols) {
+			header = make([]string, len(rows[0]))
+			for i, c := range rows[0] {
+				c = strings.TrimSpace(c)
+				if header[i] = p.columns[strings.ToLower(c)]; header[i] == "" {
+					header[i] = c
+				}
+			}
+		}
+		rows = rows[1:]
+	}
+	if header == nil {
+		return nil, errors.New(p.name + ": no header")
+	}
+
+	var entries []map[string]string
+	for _, row := range rows {
+		e := make(map[string]string)
+		for i, v := range row {
+			if i < len(header) && header[i] != "" {
+				e[header[i]] = strings.TrimSpace(v)
+			}
+		}
+		date, ok := planDate(e["Date"])
+		if !ok {
+			continue
+		}
+		e["Date"] = date
+		for _, k := range []string{"Subscription Date", "Purchase Date", "Award Date", "Vest Date"} {
+			if d, ok := planDate(e[k]); ok {
+				e[k] = d
+			}
+		}
+		for _, k := range []string{"Shares", "Quantity"} {
+			e[k] = strings.Trim(strings.TrimPrefix(e[k], "-"), "()")
+			if e[k] == "" {
+				delete(e, k)
+			}
+		}
+
+		typ := e["Transaction Type"]
+		delete(e, "Transaction Type")
+		e["Action"] = typ
+		if a, ok := p.actions[strings.ToLower(typ)]; ok {
+			e["Action"] = a.action
+			if typ != a.action {
+				e["Reported Action"] = typ
+			}
+			if a.typ != "" && e["Type"] == "" {
+				e["Type"] = a.typ
+			}
+		}
+		if e["Description"] == "" {
+			e["Description"] = typ
+		}
+		e["Administrator"] = p.name
+		for _, k := range coreKeys {
+			if _, ok := e[k]; !ok {
+				e[k] = ""
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func planDate(s string) (string, bool) {
+	for _, layout := range planDates {
+		if t, err := time.Parse(layout, s); err == nil {
+			return formatDate(t), true
+		}
+	}
+	return "", false
+}
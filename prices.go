@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// A PriceProvider looks up the price of a symbol on a day. It
+// reports false if it doesn't know it.
+type PriceProvider interface {
+	Price(symbol string, date time.Time) (Decimal, bool, error)
+}
+
+// Open the provider named by -prices: "stooq", or a CSV file.
+func priceProvider(name string) (PriceProvider, error) {
+	if name == "stooq" {
+		return &stooqPrices{cache: make(map[string]map[string]Decimal)}, nil
+	}
+	f, err := openInput(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readPrices(f)
+}
+
+// csvPrices are read from a CSV file of symbol,date,price rows.
+type csvPrices map[string]Decimal
+
+func readPrices(r io.Reader) (csvPrices, error) {
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	p := make(csvPrices)
+	for i, row := range rows {
+		if len(row) < 3 {
+			return nil, fmt.Errorf("prices line %d: want symbol,date,price", i+1)
+		}
+		date, err := parseDate(row[1])
+		if err != nil {
+			if i == 0 {
+				continue // header
+			}
+			return nil, fmt.Errorf("prices line %d: %s", i+1, err)
+		}
+		price, err := parseDecimal(row[2])
+		if err != nil {
+			return nil, fmt.Errorf("prices line %d: %s", i+1, err)
+		}
+		p[row[0]+" "+date.Format("2006-01-02")] = price
+	}
+	return p, nil
+}
+
+func (p csvPrices) Price(symbol string, date time.Time) (Decimal, bool, error) {
+	v, ok := p[symbol+" "+date.Format("2006-01-02")]
+	return v, ok, nil
+}
+
+// stooqPrices fetches daily closing prices of US listings from
+// stooq.com, a symbol at a time.
+type stooqPrices struct {
+	cache map[string]map[string]Decimal
+}
+
+func (p *stooqPrices) Price(symbol string, date time.Time) (Decimal, bool, error) {
+	days, ok := p.cache[symbol]
+	if !ok {
+		var err error
+		if days, err = p.fetch(symbol); err != nil {
+			return Decimal{}, false, err
+		}
+		p.cache[symbol] = days
+	}
+	v, ok := days[date.Format("2006-01-02")]
+	return v, ok, nil
+}
+
+func (p *stooqPrices) fetch(symbol string) (map[string]Decimal, error) {
+	url := "https://stooq.com/q/d/l/?i=d&s=" + strings.ToLower(symbol) + ".us"
+	resp, err := httpClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+
+	// Date,Open,High,Low,Close,Volume
+	rows, err := csv.NewReader(resp.Body).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", url, err)
+	}
+	days := make(map[string]Decimal)
+	for _, row := range rows {
+		if len(row) < 5 {
+			continue
+		}
+		if v, err := parseDecimal(row[4]); err == nil {
+			days[row[0]] = v
+		}
+	}
+	return days, nil
+}
+
+// Fill in the fair market value of acquisitions where the history
+// omits it, recording in "FMV Source" whether each value is from
+// the statement or was fetched.
+func fillPrices(entries []map[string]string, p PriceProvider) error {
+	for _, e := range entries {
+		if !buyActions[e["Action"]] || e["Action"] == "Lot" {
+			continue
+		}
+		if _, ok := first(e, costKeys); ok {
+			e["FMV Source"] = "statement"
+			continue
+		}
+		date, err := parseDate(e["Date"])
+		if err != nil {
+			return err
+		}
+		v, ok, err := p.Price(e["Symbol"], date)
+		if err != nil {
+			return err
+		}
+		if ok {
+			e["Fair Market Value"] = "$" + v.Fixed(2)
+			e["FMV Source"] = "fetched"
+		}
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Drop the entries dated after date, so that reports are as they
+// would have been then. Entries without a date are kept.
+func asOf(entries []map[string]string, date string) ([]map[string]string, error) {
+	t, err := parseDate(date)
+	if err != nil {
+		return nil, fmt.Errorf("bad -as-of: %s", err)
+	}
+	var kept []map[string]string
+	for _, e := range entries {
+		if d, err := parseDate(e["Date"]); err == nil && d.After(t) {
+			continue
+		}
+		kept = append(kept, e)
+	}
+	return kept, nil
+}
+
+// The canned queries, for those who'd rather not write jq or SQL.
+var queries = map[string]func(files []string) error{
+	"holdings":    holdingsQuery,
+	"gains":       gainsCommand,
+	"withholding": withholdingCommand,
+	"entries":     convert,
+}
+
+// Run a canned query on the -db (the NDJSON file written by
+// -append) and any files given, as of -as-of, if given: the
+// holdings, the realized gains, by sale and in total, or the taxes
+// withheld, or the entries themselves.
+func queryCommand(args []string) error {
+	if len(args) == 0 || queries[args[0]] == nil {
+		var names []string
+		for name := range queries {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("usage: eac2json query [-db file] [-as-of date] %s [file...]", strings.Join(names, "|"))
+	}
+	name, files := args[0], args[1:]
+	if *dbFlag != "" {
+		files = append([]string{*dbFlag}, files...)
+	}
+	if len(files) == 0 {
+		return errors.New("query needs -db or files")
+	}
+	return queries[name](files)
+}
+
+// Report the shares held, and their basis, by account and symbol.
+func holdingsQuery(files []string) error {
+	q, err := parseQuery(*queryFlag)
+	if err != nil {
+		return err
+	}
+	b, err := book(files)
+	if err != nil {
+		return err
+	}
+
+	type holding struct {
+		account, symbol string
+		shares, basis   Decimal
+		lots            int
+	}
+	var (
+		keys []string
+		held = make(map[string]*holding)
+	)
+	for _, l := range b.Lots {
+		if l.Open.Sign() == 0 {
+			continue
+		}
+		k := l.key()
+		h := held[k]
+		if h == nil {
+			h = &holding{account: l.Account, symbol: l.Symbol}
+			held[k] = h
+			keys = append(keys, k)
+		}
+		h.shares = h.shares.Add(l.Open)
+		h.basis = h.basis.Add(l.Basis)
+		h.lots++
+	}
+	sort.Strings(keys)
+
+	var records []map[string]string
+	for _, k := range keys {
+		h := held[k]
+		r := map[string]string{
+			"Account": h.account,
+			"Symbol":  h.symbol,
+			"Shares":  h.shares.String(),
+			"Basis":   h.basis.Fixed(2),
+			"Lots":    fmt.Sprint(h.lots),
+		}
+		switch {
+		case *asOfFlag != "":
+			r["As Of"] = *asOfFlag
+		case !b.Through.IsZero():
+			r["As Of"] = formatDate(b.Through)
+		}
+		records = append(records, r)
+	}
+	return emit(os.Stdout, records, q)
+}
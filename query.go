@@ -0,0 +1,229 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A query is a simple jq-like pipeline applied to the output
+// before it is written. Stages are separated by "|":
+//
+//	.key, .["key"]       select a key; applied to each element of an array
+//	.[n], .[n:m]         index or slice an array
+//	.[]                  the array itself
+//	select(.key == "v")  keep the elements for which key is (or, with
+//	                     !=, is not) v
+//	length               the length of an array or object
+//
+// For example:
+//
+//	select(.Action == "Sale") | .["Sale Price"]
+type query []func(interface{}) (interface{}, error)
+
+func parseQuery(s string) (query, error) {
+	var q query
+	if strings.TrimSpace(s) == "" {
+		return q, nil
+	}
+	for _, stage := range splitQuery(s) {
+		stage = strings.TrimSpace(stage)
+		f, err := parseStage(stage)
+		if err != nil {
+			return nil, fmt.Errorf("query %q: %s", stage, err)
+		}
+		q = append(q, f)
+	}
+	return q, nil
+}
+
+// Split a query into stages at "|" that aren't quoted.
+func splitQuery(s string) []string {
+	var (
+		stages []string
+		quoted bool
+		start  int
+	)
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			quoted = !quoted
+		case '|':
+			if !quoted {
+				stages = append(stages, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(stages, s[start:])
+}
+
+func parseStage(s string) (func(interface{}) (interface{}, error), error) {
+	switch {
+	case s == "." || s == ".[]":
+		return func(v interface{}) (interface{}, error) { return v, nil }, nil
+
+	case s == "length":
+		return func(v interface{}) (interface{}, error) {
+			switch v := v.(type) {
+			case []interface{}:
+				return len(v), nil
+			case map[string]interface{}:
+				return len(v), nil
+			case string:
+				return len(v), nil
+			}
+			return nil, errors.New("length of non-collection")
+		}, nil
+
+	case strings.HasPrefix(s, "select(") && strings.HasSuffix(s, ")"):
+		return parseSelect(s[len("select(") : len(s)-1])
+
+	case strings.HasPrefix(s, ".[") && strings.HasSuffix(s, "]") && !strings.HasPrefix(s, `.["`):
+		return parseIndex(s[2 : len(s)-1])
+	}
+
+	key, err := parseKey(s)
+	if err != nil {
+		return nil, err
+	}
+	return func(v interface{}) (interface{}, error) {
+		return each(v, func(e interface{}) interface{} {
+			if m, ok := e.(map[string]interface{}); ok {
+				return m[key]
+			}
+			return nil
+		}), nil
+	}, nil
+}
+
+// Parse .key or .["key"].
+func parseKey(s string) (string, error) {
+	switch {
+	case strings.HasPrefix(s, `.["`) && strings.HasSuffix(s, `"]`):
+		return strconv.Unquote(s[2 : len(s)-1])
+	case strings.HasPrefix(s, ".") && len(s) > 1 && !strings.ContainsAny(s, ` "[]()`):
+		return s[1:], nil
+	}
+	return "", errors.New("bad key")
+}
+
+func parseIndex(s string) (func(interface{}) (interface{}, error), error) {
+	lo, hi, slice := s, "", false
+	if i := strings.Index(s, ":"); i >= 0 {
+		lo, hi, slice = s[:i], s[i+1:], true
+	}
+
+	atoi := func(s string, def int) (int, error) {
+		if s = strings.TrimSpace(s); s == "" {
+			return def, nil
+		}
+		return strconv.Atoi(s)
+	}
+
+	return func(v interface{}) (interface{}, error) {
+		a, ok := v.([]interface{})
+		if !ok {
+			return nil, errors.New("index of non-array")
+		}
+		// Negative indices count from the end, as in jq.
+		clamp := func(i int) int {
+			if i < 0 {
+				i += len(a)
+			}
+			if i < 0 {
+				i = 0
+			}
+			if i > len(a) {
+				i = len(a)
+			}
+			return i
+		}
+
+		i, err := atoi(lo, 0)
+		if err != nil {
+			return nil, err
+		}
+		if !slice {
+			if i = clamp(i); i >= len(a) {
+				return nil, nil
+			}
+			return a[i], nil
+		}
+		j, err := atoi(hi, len(a))
+		if err != nil {
+			return nil, err
+		}
+		i, j = clamp(i), clamp(j)
+		if i > j {
+			i = j
+		}
+		return a[i:j], nil
+	}, nil
+}
+
+func parseSelect(s string) (func(interface{}) (interface{}, error), error) {
+	op := "=="
+	i := strings.Index(s, op)
+	if i < 0 {
+		op = "!="
+		i = strings.Index(s, op)
+	}
+	if i < 0 {
+		return nil, errors.New("select needs == or !=")
+	}
+
+	key, err := parseKey(strings.TrimSpace(s[:i]))
+	if err != nil {
+		return nil, err
+	}
+	want, err := strconv.Unquote(strings.TrimSpace(s[i+len(op):]))
+	if err != nil {
+		return nil, errors.New("select needs a quoted value")
+	}
+
+	return func(v interface{}) (interface{}, error) {
+		a, ok := v.([]interface{})
+		if !ok {
+			return nil, errors.New("select on non-array")
+		}
+		var out []interface{}
+		for _, e := range a {
+			m, _ := e.(map[string]interface{})
+			got, _ := m[key].(string)
+			if (got == want) == (op == "==") {
+				out = append(out, e)
+			}
+		}
+		if out == nil {
+			out = []interface{}{}
+		}
+		return out, nil
+	}, nil
+}
+
+// Apply f to v, or to each element of v if it is an array.
+func each(v interface{}, f func(interface{}) interface{}) interface{} {
+	a, ok := v.([]interface{})
+	if !ok {
+		return f(v)
+	}
+	out := make([]interface{}, len(a))
+	for i, e := range a {
+		out[i] = f(e)
+	}
+	return out
+}
+
+func (q query) Eval(v interface{}) (interface{}, error) {
+	for _, f := range q {
+		var err error
+		if v, err = f(v); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
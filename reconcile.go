@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The columns of Schwab's cost basis exports (the realized and
+// unrealized gain/loss lot details), as variously labelled.
+var costBasisColumns = map[string]string{
+	"symbol":                "Symbol",
+	"open date":             "Acquired",
+	"opened date":           "Acquired",
+	"date acquired":         "Acquired",
+	"acquired date":         "Acquired",
+	"closed date":           "Sold",
+	"date sold":             "Sold",
+	"sold date":             "Sold",
+	"quantity":              "Quantity",
+	"qty":                   "Quantity",
+	"shares":                "Quantity",
+	"cost basis":            "Cost Basis",
+	"cost basis (cb)":       "Cost Basis",
+	"cost basis ($)":        "Cost Basis",
+	"adjusted cost basis":   "Cost Basis",
+	"cost basis (adjusted)": "Cost Basis",
+}
+
+// A brokerage lot, as reported or computed, by symbol, date
+// acquired and, if closed, date sold.
+type basisKey struct {
+	symbol         string
+	acquired, sold time.Time
+}
+
+type basisLot struct {
+	shares, basis Decimal
+}
+
+func (l *basisLot) add(shares, basis Decimal) {
+	l.shares = l.shares.Add(shares)
+	l.basis = l.basis.Add(basis)
+}
+
+// Read a Schwab cost basis export. These open with a title line or
+// two before the header, and close with totals, which are skipped,
+// as are lots acquired on "Various" dates.
+func readCostBasis(file string) (map[basisKey]*basisLot, error) {
+	f, err := openInput(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+
+	var header []string
+	for len(rows) > 0 && header == nil {
+		h := make([]string, len(rows[0]))
+		have := make(map[string]bool)
+		for i, c := range rows[0] {
+			h[i] = costBasisColumns[strings.ToLower(strings.TrimSpace(c))]
+			have[h[i]] = true
+		}
+		if have["Acquired"] && have["Quantity"] && have["Cost Basis"] {
+			header = h
+		}
+		rows = rows[1:]
+	}
+	if header == nil {
+		return nil, fmt.Errorf("%s: no cost basis header", file)
+	}
+
+	lots := make(map[basisKey]*basisLot)
+	for _, row := range rows {
+		m := make(map[string]string)
+		for i, v := range row {
+			if i < len(header) && header[i] != "" {
+				m[header[i]] = strings.TrimSpace(v)
+			}
+		}
+		var k basisKey
+		if k.acquired, err = parseDate(m["Acquired"]); err != nil {
+			continue
+		}
+		if m["Sold"] != "" {
+			if k.sold, err = parseDate(m["Sold"]); err != nil {
+				continue
+			}
+		}
+		k.symbol = m["Symbol"]
+		shares, ok := amount(m, "Quantity")
+		if !ok {
+			continue
+		}
+		basis, _ := amount(m, "Cost Basis")
+		if lots[k] == nil {
+			lots[k] = new(basisLot)
+		}
+		lots[k].add(shares, basis)
+	}
+	return lots, nil
+}
+
+// Reconcile the basis a broker reports, in a Schwab cost basis
+// export, against that computed for the brokerage lots, open and
+// sold, from the entries of the given sources. Lots are matched by
+// symbol and the dates acquired and sold. Brokers routinely report
+// zero (or no) basis for shares from vests, so these are marked
+// apart from other mismatches. Computed lots of symbols the export
+// doesn't cover are left out.
+func reconcileCommand(args []string) error {
+	if len(args) < 1 {
+		return errors.New("usage: eac2json reconcile cost-basis.csv [file...]")
+	}
+	q, err := parseQuery(*queryFlag)
+	if err != nil {
+		return err
+	}
+	reported, err := readCostBasis(args[0])
+	if err != nil {
+		return err
+	}
+	b, err := book(args[1:])
+	if err != nil {
+		return err
+	}
+
+	cent := Decimal{big.NewRat(1, 100)}
+	computed := make(map[basisKey]*basisLot)
+	add := func(k basisKey, shares, basis Decimal) {
+		if computed[k] == nil {
+			computed[k] = new(basisLot)
+		}
+		computed[k].add(shares, basis)
+	}
+	for _, l := range b.Lots {
+		if l.Account == "brokerage" && l.Open.Sign() != 0 {
+			add(basisKey{l.Symbol, l.Acquired, time.Time{}}, l.Open, l.Basis)
+		}
+	}
+	for _, s := range b.Sales {
+		if s.lot == nil || s.lot.Account == "brokerage" {
+			add(basisKey{s.Symbol, s.Acquired, s.Sold}, s.Shares, s.Basis)
+		}
+	}
+
+	symbols := make(map[string]bool)
+	var keys []basisKey
+	for k := range reported {
+		symbols[k.symbol] = true
+		keys = append(keys, k)
+	}
+	for k := range computed {
+		if reported[k] == nil && symbols[k.symbol] {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		switch {
+		case !a.acquired.Equal(b.acquired):
+			return a.acquired.Before(b.acquired)
+		case !a.sold.Equal(b.sold):
+			return a.sold.Before(b.sold)
+		}
+		return a.symbol < b.symbol
+	})
+
+	var records []map[string]string
+	for _, k := range keys {
+		r := map[string]string{
+			"Symbol":   k.symbol,
+			"Acquired": formatDate(k.acquired),
+		}
+		if !k.sold.IsZero() {
+			r["Sold"] = formatDate(k.sold)
+		}
+		rep, comp := reported[k], computed[k]
+		if rep != nil {
+			r["Reported Shares"] = rep.shares.String()
+			r["Reported Basis"] = rep.basis.Fixed(2)
+		}
+		if comp != nil {
+			r["Computed Shares"] = comp.shares.String()
+			r["Computed Basis"] = comp.basis.Fixed(2)
+		}
+		switch {
+		case comp == nil:
+			r["Status"] = "missing"
+		case rep == nil:
+			r["Status"] = "unreported"
+		case rep.shares.Cmp(comp.shares) != 0:
+			r["Status"] = "shares"
+		case rep.basis.Sign() == 0 && comp.basis.Sign() != 0:
+			r["Status"] = "zero basis"
+			r["Difference"] = comp.basis.Fixed(2)
+		case comp.basis.Sub(rep.basis).Abs().Cmp(cent) >= 0:
+			r["Status"] = "mismatch"
+			r["Difference"] = comp.basis.Sub(rep.basis).Fixed(2)
+		default:
+			r["Status"] = "ok"
+		}
+		records = append(records, r)
+	}
+	return emit(os.Stdout, records, q)
+}
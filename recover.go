@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+
+	"golang.org/x/net/html"
+)
+
+// A row skipped by -recover, with the HTML of it and its details,
+// so that it can be looked at (or fixed up with -overlay) later.
+type recovered struct {
+	Row   []string `json:"row"`
+	Error string   `json:"error"`
+	HTML  string   `json:"html"`
+}
+
+var recoveredRows []recovered
+
+// Record the row at n as skipped for err.
+func recoverRow(n *Node, err error) {
+	values, _ := row(n)
+	var b bytes.Buffer
+	html.Render(&b, n.Node)
+	if isDetail(n) {
+		d := *n
+		d.Sibling("tr")
+		html.Render(&b, d.Node)
+	}
+	log.Printf("skipping row %q: %s", values, err)
+	recoveredRows = append(recoveredRows, recovered{values, err.Error(), b.String()})
+}
+
+// Write the rows skipped by -recover to file, as a JSON array.
+func writeRecovered(file string) error {
+	rows := recoveredRows
+	if rows == nil {
+		rows = []recovered{}
+	}
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "\t")
+	if err := enc.Encode(rows); err != nil {
+		return err
+	}
+	return os.WriteFile(file, b.Bytes(), 0666)
+}
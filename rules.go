@@ -0,0 +1,341 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+)
+
+// A Rule says how to handle history rows whose Action and
+// Description match its patterns (an empty pattern matches
+// anything). Do is one of:
+//
+//	emit    emit the row on its own; it has no details
+//	drop    ignore the row; it has no details
+//	skip    ignore the row and its details
+//	fields  emit the row merged with its key/value details
+//	merge   emit the row merged with its single detail table row;
+//	        several rows (e.g. multi-lot sells) are split
+//	split   emit an entry for each detail table row, with the
+//	        row's core keys
+//	auto    emit the row with its details, if any, merged or
+//	        split according to their layout
+//
+// Details laid out other than as fields, merge, or split expect
+// are parsed as they are laid out (see paneStyle).
+//
+// Keys, if given, renames keys of the resulting entries, so that
+// details labelled differently come out the same.
+//
+// Rules are given in JSON, e.g.:
+//
+//	[{"action": "^Sell to Cover$", "do": "merge"},
+//	 {"action": "^Vest$", "do": "auto", "keys": {"Vest FMV": "Fair Market Value"}}]
+type Rule struct {
+	Action      string            `json:"action"`
+	Description string            `json:"description"`
+	Do          string            `json:"do"`
+	Keys        map[string]string `json:"keys"`
+
+	action, description *regexp.Regexp
+}
+
+// The built-in rules, to the best of my understanding of the
+// record types.
+var defaultRules = []Rule{
+	{Action: "^Lapse$", Do: "fields"},
+
+	// Newer plans call lapses releases, with their details laid
+	// out as a table, and labelled differently.
+	{Action: "^Release$", Do: "auto", Keys: releaseKeys},
+
+	// Schwab sells shares for taxes by first depositing them
+	// to your EAC account,and then selling them.
+	// Remaining shares go into your brokerage account.
+	{Action: "^(Deposit|Forced Quick Sell)$", Do: "merge"},
+
+	// Plans configured for sell-to-cover sell shares for taxes
+	// this way instead; quick sells are voluntary sales from the
+	// EAC account. Their details are laid out the same.
+	{Action: "^(Sell to Cover|Quick Sell)$", Do: "merge"},
+
+	// ISO exercise and hold. The details pane here may
+	// contain multiple entries that have different prices.
+	// We break this up into multiple entries.
+	//
+	// "Sale" is for other sales.
+	//
+	// XXX looks like ESPPs are sold directly in the brokeage account.
+	// XXX take care of this next
+	{Action: "^(Exer and Hold|Sale)$", Do: "split"},
+
+	// Cash dividends have no details. Reinvested dividends buy
+	// shares, given in the details with their price; these make
+	// new lots.
+	{Action: "^Dividend$", Do: "emit"},
+	{Action: "^Dividend Reinvestment$", Do: "merge"},
+
+	// Dividend equivalents on unvested units, paid in cash or as
+	// additional units.
+	{Action: "^(Dividend Equivalent|DER)$", Do: "auto"},
+
+	// Grants that lapse unexercised, are cancelled, or are
+	// forfeited (e.g. on termination). Layouts vary.
+	{Action: "^(Expiration|Cancellation|Forfeiture)$", Do: "auto"},
+
+	// Corrections to earlier entries; see linkCorrections.
+	{Action: "^(Adjustment|Correction|Reversal)$", Do: "auto"},
+
+	// The next row holds more details, but it's not useful to us.
+	{Action: "^Journal$", Do: "skip"},
+
+	// Not relevant for our purposes. Also they don't contain any
+	// extra rows.
+	{Action: "^Forced Disbursement$", Do: "drop"},
+}
+
+// The keys of release details, and their names in lapses.
+var releaseKeys = map[string]string{
+	"Grant Date":         "Award Date",
+	"Grant ID":           "Award ID",
+	"Grant Number":       "Award ID",
+	"Release FMV":        "Fair Market Value",
+	"Release Price":      "Fair Market Value",
+	"FMV":                "Fair Market Value",
+	"Net Shares":         "Net Shares Deposited",
+	"Shares Deposited":   "Net Shares Deposited",
+	"Total Taxes":        "Taxes",
+	"Total Tax Withheld": "Taxes",
+	"Taxes Withheld":     "Taxes",
+}
+
+func (r *Rule) compile() error {
+	switch r.Do {
+	case "emit", "drop", "skip", "fields", "merge", "split", "auto":
+	default:
+		return fmt.Errorf("rule %q: bad \"do\" %q", r.Action, r.Do)
+	}
+
+	var err error
+	if r.action, err = regexp.Compile(r.Action); err != nil {
+		return fmt.Errorf("rule %q: bad \"action\": %s", r.Action, err)
+	}
+	if r.description, err = regexp.Compile(r.Description); err != nil {
+		return fmt.Errorf("rule %q: bad \"description\" %q: %s", r.Action, r.Description, err)
+	}
+	return nil
+}
+
+func (r *Rule) Match(row map[string]string) bool {
+	return r.action.MatchString(row["Action"]) &&
+		r.description.MatchString(row["Description"])
+}
+
+// With -journals, journals are emitted with their details: the
+// shares moved to the brokerage account, and when.
+var journalRule = Rule{Action: "^Journal$", Do: "split"}
+
+// Load rules from a JSON file; they take precedence over the
+// built-in rules (and -journals).
+func loadRules(file string) ([]Rule, error) {
+	var rules []Rule
+	if file != "" {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(b, &rules); err != nil {
+			return nil, fmt.Errorf("%s: %s", file, err)
+		}
+	}
+	if *journalsFlag {
+		rules = append(rules, journalRule)
+	}
+	rules = append(rules, defaultRules...)
+
+	for i := range rules {
+		if err := rules[i].compile(); err != nil {
+			return nil, err
+		}
+	}
+	return rules, nil
+}
+
+// The style in which to parse the detail pane at n, given the
+// rule's: key/value panes are parsed as fields, and columnar ones
+// merged or split according to their rows.
+func paneStyle(n *Node, do string) string {
+	fields, _ := more1(n, ignore)
+	d := &Detail{Fields: fields}
+	if d.keyed() {
+		return "fields"
+	}
+	if do == "fields" || do == "merge" {
+		if rows, _ := more(n, ignore); len(rows) > 1 {
+			return "split"
+		}
+		return "merge"
+	}
+	return do
+}
+
+// Find the first rule matching row.
+func matchRule(rules []Rule, row map[string]string) *Rule {
+	for i := range rules {
+		if rules[i].Match(row) {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+// Apply the rule to a row, writing the resulting entries to the
+// ledger. The node is advanced past any details.
+func (r *Rule) Apply(l *Ledger, n *Node, row map[string]string) error {
+	write := func(m map[string]string) {
+		for k, v := range m {
+			if to, ok := r.Keys[k]; ok {
+				k = to
+			}
+			l.Write(k, v)
+		}
+	}
+	// Split entries have only the row's core keys, and the action
+	// as reported, if aliased.
+	core := func() {
+		for _, k := range coreKeys {
+			l.Write(k, row[k])
+		}
+		if v, ok := row["Reported Action"]; ok {
+			l.Write("Reported Action", v)
+		}
+	}
+	warn := func(format string, args ...interface{}) {
+		log.Printf("%s %s: %s", row["Date"], row["Action"], fmt.Sprintf(format, args...))
+	}
+
+	// Details are sometimes left out (e.g. for sales without fees);
+	// the next row is then another entry, not to be taken for them.
+	// Their layout varies by plan and era, so they are parsed as
+	// they are laid out, whatever the rule expects.
+	do := r.Do
+	switch do {
+	case "fields", "merge", "split":
+		if !isDetail(n) {
+			warn("no details")
+			l.Next()
+			write(row)
+			return nil
+		}
+		n.Sibling("tr")
+		do = paneStyle(n, do)
+	}
+
+	switch do {
+	case "emit":
+		l.Next()
+		write(row)
+
+	case "drop":
+
+	case "skip":
+		if isDetail(n) {
+			n.Sibling("tr")
+		}
+
+	case "fields":
+		entries, err := more1(n, warn)
+		if err != nil {
+			return err
+		}
+
+		l.Next()
+		write(row)
+		write(entries)
+		write(extra(n, warn))
+
+	case "merge":
+		entries, err := more(n, warn)
+		if err != nil {
+			return err
+		}
+		if len(entries) != 1 {
+			return fmt.Errorf("Expected one row; got %d", len(entries))
+		}
+		if err := checkProceeds(row, entries); err != nil {
+			log.Print(err)
+		}
+
+		l.Next()
+		write(row)
+		write(entries[0])
+		write(extra(n, warn))
+
+	case "split":
+		entries, err := more(n, warn)
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			return fmt.Errorf("empty \"more details\" for %s", row["Action"])
+		}
+		if err := checkProceeds(row, entries); err != nil {
+			log.Print(err)
+		}
+
+		// The further tables are the row's, not each lot's, so
+		// they go with the first entry only, lest they be counted
+		// once per lot. So do the row's own fields, such as its
+		// amount and fees, when it is split only because its pane
+		// is laid out as a table.
+		x := extra(n, warn)
+		for i, e := range entries {
+			l.Next()
+			if i == 0 && r.Do != "split" {
+				write(row)
+			} else {
+				core()
+			}
+			write(e)
+			if i == 0 {
+				write(x)
+			}
+		}
+
+	case "auto":
+		d, err := detail(n)
+		if err != nil {
+			return err
+		}
+		switch {
+		case d == nil:
+			l.Next()
+			write(row)
+		case d.keyed():
+			l.Next()
+			write(row)
+			write(d.Fields)
+			write(d.Extra)
+		case len(d.Table) <= 1:
+			l.Next()
+			write(row)
+			for _, e := range d.Table {
+				write(e)
+			}
+			write(d.Extra)
+		default:
+			for i, e := range d.Table {
+				l.Next()
+				core()
+				write(e)
+				if i == 0 {
+					write(d.Extra)
+				}
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// The version of the output schema. Output written with -envelope
+// records it, so that archives can be migrated as the schema
+// evolves. Bare arrays of entries, as written by earlier releases,
+// are version 1.
+//
+//	1	entries only
+//	2	entries have a synthetic "ID" (see identify)
+//	3	entries have their ordinal as "Seq"
+const schemaVersion = 3
+
+// Migrations upgrade entries from version i+1 to i+2.
+var migrations = []func([]map[string]string){
+	identify,
+	number,
+}
+
+// Number entries in order.
+func number(entries []map[string]string) {
+	for i, e := range entries {
+		if e["Seq"] == "" {
+			e["Seq"] = strconv.Itoa(i + 1)
+		}
+	}
+}
+
+// An envelope wraps output with its schema version, and events
+// with their interchange schema (see eventsSchema).
+type envelope struct {
+	SchemaVersion int         `json:"schema_version"`
+	Schema        string      `json:"schema,omitempty"`
+	Entries       interface{} `json:"entries"`
+}
+
+// Whether a JSON object is an envelope.
+func isEnvelope(b []byte) bool {
+	var env struct {
+		SchemaVersion *int `json:"schema_version"`
+	}
+	return json.Unmarshal(b, &env) == nil && env.SchemaVersion != nil
+}
+
+// Read an archive of entries, either a bare array or an envelope,
+// and migrate it to the current schema version.
+func readArchive(b []byte) ([]map[string]string, error) {
+	var (
+		raw     []map[string]interface{}
+		version = 1
+	)
+	if err := json.Unmarshal(b, &raw); err != nil {
+		var env struct {
+			SchemaVersion int                      `json:"schema_version"`
+			Entries       []map[string]interface{} `json:"entries"`
+		}
+		if err := json.Unmarshal(b, &env); err != nil {
+			return nil, err
+		}
+		raw, version = env.Entries, env.SchemaVersion
+	}
+	if version < 1 || version > schemaVersion {
+		return nil, fmt.Errorf("unknown schema version %d", version)
+	}
+
+	entries := make([]map[string]string, len(raw))
+	for i, m := range raw {
+		entries[i] = stringMap(m)
+	}
+	for _, m := range migrations[version-1:] {
+		m(entries)
+	}
+	return entries, nil
+}
+
+// Upgrade an archive written by an earlier release to the current
+// schema, writing it in an envelope. The entries are written as
+// they are: output flags, such as -offset or -empty, don't apply.
+func migrateCommand(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: eac2json migrate old.json")
+	}
+	b, err := readInput(args[0])
+	if err != nil {
+		return err
+	}
+	entries, err := readArchive(b)
+	if err != nil {
+		return fmt.Errorf("%s: %s", args[0], err)
+	}
+	return json.NewEncoder(os.Stdout).Encode(envelope{SchemaVersion: schemaVersion, Entries: entries})
+}
+
+// Convert archived output to -format, without the pages it was made
+// from: the archives may be JSON, bare or in an envelope (and are
+// migrated as by migrate), NDJSON, as written by -append, or CSV
+// (separated by -csv-delimiter) or TSV, as written by -format, if
+// so named. The entries are written as they are, in order, less
+// -fields if given.
+func convertCommand(args []string) error {
+	q, err := parseQuery(*queryFlag)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return errors.New("usage: eac2json convert [-format format] archive...")
+	}
+	var entries []map[string]string
+	for _, file := range args {
+		b, err := readInput(file)
+		if err != nil {
+			return err
+		}
+		var es []map[string]string
+		switch {
+		case strings.HasSuffix(file, ".csv"):
+			es, err = readTable(b, csvDelimiter())
+		case strings.HasSuffix(file, ".tsv"):
+			es, err = readTable(b, '\t')
+		case isNDJSON(b):
+			es, err = parseNDJSON(bytes.NewReader(b))
+		default:
+			es, err = readArchive(b)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %s", file, err)
+		}
+		entries = append(entries, es...)
+	}
+	switch *formatFlag {
+	case "csv", "tsv", "table":
+		entries = renderText(window(entries))
+		return report(os.Stdout, columns(entries), entries, q)
+	}
+	return emitEntries(os.Stdout, entries, q)
+}
+
+// Read entries from CSV, separated by comma (as -csv-delimiter), or
+// unquoted TSV, with a header row. Empty cells are dropped, as the
+// table gives every entry every key. A header of a single column
+// that holds another likely delimiter is taken for one read with
+// the wrong delimiter, rather than for a table of one column.
+func readTable(b []byte, comma rune) ([]map[string]string, error) {
+	var rows [][]string
+	if comma == '\t' {
+		for _, line := range strings.Split(strings.TrimRight(string(b), "\r\n"), "\n") {
+			rows = append(rows, strings.Split(strings.TrimSuffix(line, "\r"), "\t"))
+		}
+	} else {
+		r := csv.NewReader(bytes.NewReader(b))
+		r.Comma = comma
+		r.FieldsPerRecord = -1
+		var err error
+		if rows, err = r.ReadAll(); err != nil {
+			return nil, err
+		}
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	if len(rows[0]) == 1 {
+		for _, c := range ",;\t|" {
+			if c != comma && strings.ContainsRune(rows[0][0], c) {
+				return nil, fmt.Errorf("header has one column, but holds %q; wrong -csv-delimiter?", c)
+			}
+		}
+	}
+	for i, row := range rows {
+		if len(row) != len(rows[0]) {
+			return nil, fmt.Errorf("line %d: %d fields for %d columns", i+1, len(row), len(rows[0]))
+		}
+	}
+	var entries []map[string]string
+	for _, row := range rows[1:] {
+		e := make(map[string]string)
+		for i, v := range row {
+			if v != "" && i < len(rows[0]) {
+				e[rows[0][i]] = v
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
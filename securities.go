@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"strings"
+)
+
+// A security master maps symbols to identifying fields, such as
+// CUSIP, ISIN, and security name. It is read from a CSV file whose
+// header names the columns, one of which must be "Symbol".
+type securities map[string]map[string]string
+
+func readSecurities(file string) (securities, error) {
+	f, err := openInput(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("%s: no header", file)
+	}
+
+	header := rows[0]
+	sym := -1
+	for i, h := range header {
+		header[i] = strings.TrimSpace(h)
+		if strings.EqualFold(header[i], "Symbol") {
+			sym = i
+		}
+	}
+	if sym < 0 {
+		return nil, fmt.Errorf("%s: no Symbol column", file)
+	}
+
+	s := make(securities)
+	for _, row := range rows[1:] {
+		m := make(map[string]string)
+		for i, v := range row {
+			if i != sym && i < len(header) {
+				m[header[i]] = strings.TrimSpace(v)
+			}
+		}
+		s[strings.TrimSpace(row[sym])] = m
+	}
+	return s, nil
+}
+
+// Add the security master's fields to each entry, without
+// overwriting what's there.
+func (s securities) Enrich(entries []map[string]string) {
+	for _, e := range entries {
+		for k, v := range s[e["Symbol"]] {
+			if _, ok := e[k]; !ok {
+				e[k] = v
+			}
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Check a converted page against the expected entries, returning
+// a description of the first difference.
+func compare(got, want []map[string]string) error {
+	if len(got) != len(want) {
+		return fmt.Errorf("%d entries; expected %d", len(got), len(want))
+	}
+	for i := range got {
+		if reflect.DeepEqual(got[i], want[i]) {
+			continue
+		}
+		var keys []string
+		for k := range got[i] {
+			keys = append(keys, k)
+		}
+		for k := range want[i] {
+			if _, ok := got[i][k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			g, gok := got[i][k]
+			w, wok := want[i][k]
+			if g != w || gok != wok {
+				return fmt.Errorf("entry %d: %s is %q; expected %q", i, k, g, w)
+			}
+		}
+	}
+	return nil
+}
+
+// Run the parser over a corpus of fixture pages, each of which is
+// accompanied by its expected output as a .json file of the same
+// name; e.g. history.html and history.json. Pages are grouped by
+// layout version, as named by the subdirectory they're in, and the
+// results reported per layout. Mismatches are logged.
+func selftestCommand(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: eac2json selftest dir")
+	}
+	q, err := parseQuery(*queryFlag)
+	if err != nil {
+		return err
+	}
+
+	type result struct{ pages, passed int }
+	var (
+		layouts []string
+		results = make(map[string]*result)
+		failed  bool
+	)
+	err = filepath.Walk(args[0], func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || filepath.Ext(path) == ".json" {
+			return err
+		}
+		want := strings.TrimSuffix(path, filepath.Ext(path)) + ".json"
+		b, err := os.ReadFile(want)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		layout, _ := filepath.Rel(args[0], filepath.Dir(path))
+		r := results[layout]
+		if r == nil {
+			r = new(result)
+			results[layout] = r
+			layouts = append(layouts, layout)
+		}
+		r.pages++
+
+		expected, err := readArchive(b)
+		if err != nil {
+			return fmt.Errorf("%s: %s", want, err)
+		}
+		got, err := load([]string{path})
+		if err == nil {
+			err = compare(got, expected)
+		}
+		if err != nil {
+			log.Printf("%s: %s", path, err)
+			failed = true
+			return nil
+		}
+		r.passed++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Strings(layouts)
+	var records []map[string]string
+	for _, l := range layouts {
+		r := results[l]
+		records = append(records, map[string]string{
+			"Layout": l,
+			"Pages":  fmt.Sprint(r.pages),
+			"Passed": fmt.Sprint(r.passed),
+			"Failed": fmt.Sprint(r.pages - r.passed),
+		})
+	}
+	if err := emit(os.Stdout, records, q); err != nil {
+		return err
+	}
+	if failed {
+		return errors.New("selftest failed")
+	}
+	return nil
+}
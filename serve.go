@@ -0,0 +1,520 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long finished jobs are kept for their results to be fetched.
+const jobTTL = time.Hour
+
+// The formats in which results may be requested.
+var formats = map[string]string{
+	"json":   "application/json",
+	"ndjson": "application/x-ndjson",
+	"csv":    "text/csv",
+	"tsv":    "text/tab-separated-values",
+}
+
+// A job is an asynchronous conversion.
+type job struct {
+	ID     string    `json:"id"`
+	Status string    `json:"status"` // pending, running, done, or failed
+	Error  string    `json:"error,omitempty"`
+	Format string    `json:"format"`
+	Done   time.Time `json:"-"`
+
+	result []byte
+}
+
+// Conversions are serialized, as load records its inputs for
+// -manifest globally.
+var loading sync.Mutex
+
+type server struct {
+	mu      sync.Mutex
+	jobs    map[string]*job
+	metrics metrics
+	slots   chan struct{} // one per conversion admitted; see -max-jobs
+}
+
+// Upper bounds, in seconds, of the conversion latency histogram.
+var latencyBuckets = []float64{.01, .05, .1, .5, 1, 5, 10, 30, 60}
+
+// Counters of conversions, exposed at /metrics.
+type metrics struct {
+	mu       sync.Mutex
+	parses   int
+	failures map[string]int // by layout, as sniffed
+	timeouts int
+	buckets  []int // counts, by latencyBuckets
+	count    int
+	sum      float64
+}
+
+// Serve conversions over HTTP:
+//
+//	POST /convert        convert the uploaded files, returning the result
+//	POST /jobs           submit the uploaded files for conversion
+//	GET  /jobs/ID        poll a job's status
+//	GET  /jobs/ID/result download a finished job's result
+//
+// Files are uploaded as multipart form data, in any number of
+// "file" fields. The result's format is selected by the "format"
+// parameter: json (the default), ndjson, csv, or tsv.
+//
+// Counters of conversions, failures by layout, and latency are
+// served at /metrics, in Prometheus' text format. With -token, all
+// requests must present it as "Authorization: Bearer token".
+// Requests are limited to -max-upload bytes, and conversions to
+// -timeout. At most -max-jobs conversions are admitted at once,
+// running or waiting; further requests are refused until one
+// finishes.
+func serveCommand(args []string) error {
+	if len(args) != 0 {
+		return errors.New("usage: eac2json serve [-addr addr] [-token token]")
+	}
+	if *maxJobsFlag < 1 {
+		return fmt.Errorf("bad -max-jobs %d", *maxJobsFlag)
+	}
+	s := &server{jobs: make(map[string]*job), slots: make(chan struct{}, *maxJobsFlag)}
+	s.metrics.failures = make(map[string]int)
+	s.metrics.buckets = make([]int, len(latencyBuckets))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/convert", s.convert)
+	mux.HandleFunc("/jobs", s.submit)
+	mux.HandleFunc("/jobs/", s.job)
+	mux.HandleFunc("/metrics", s.serveMetrics)
+	srv := &http.Server{
+		Addr:              *addrFlag,
+		Handler:           s.auth(mux),
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       *timeoutFlag,
+	}
+	log.Printf("serving on %s", *addrFlag)
+	return srv.ListenAndServe()
+}
+
+// Check the request's token, and limit its size.
+func (s *server) auth(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if *tokenFlag != "" {
+			got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(*tokenFlag)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, *maxUploadFlag)
+		h.ServeHTTP(w, r)
+	})
+}
+
+// An upload is a conversion request's files, saved into a temporary
+// directory, which must be removed when done.
+type uploaded struct {
+	dir     string
+	files   []string
+	names   map[string]string // uploaded file names, by path
+	layouts map[string]string // as sniffed, by path
+	format  string
+}
+
+// Parse a conversion request, saving its files.
+func upload(r *http.Request) (*uploaded, error) {
+	u := &uploaded{names: make(map[string]string), layouts: make(map[string]string)}
+	u.format = r.FormValue("format")
+	if u.format == "" {
+		u.format = "json"
+	}
+	if _, ok := formats[u.format]; !ok {
+		return nil, fmt.Errorf("bad format %q", u.format)
+	}
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		return nil, err
+	}
+	headers := r.MultipartForm.File["file"]
+	if len(headers) == 0 {
+		return nil, errors.New("no files")
+	}
+
+	var err error
+	if u.dir, err = os.MkdirTemp("", "eac2json"); err != nil {
+		return nil, err
+	}
+	for i, h := range headers {
+		name := filepath.Join(u.dir, fmt.Sprint(i))
+		if err := save(h, name); err != nil {
+			os.RemoveAll(u.dir)
+			return nil, err
+		}
+		u.files = append(u.files, name)
+		u.names[name] = h.Filename
+		u.layouts[name] = layout(name)
+	}
+	return u, nil
+}
+
+// The layout of a file, as sniffed, for metrics.
+func layout(name string) string {
+	f, err := os.Open(name)
+	if err != nil {
+		return "unknown"
+	}
+	defer f.Close()
+	kind, err := sniff(bufio.NewReader(f))
+	if err != nil {
+		return "unknown"
+	}
+	return kind
+}
+
+func (u *uploaded) Close() error {
+	return os.RemoveAll(u.dir)
+}
+
+func save(h *multipart.FileHeader, name string) error {
+	f, err := h.Open()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, f); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// A conversion failure, with the layout of the file that failed.
+type convertError struct {
+	layout string
+	err    error
+}
+
+func (e *convertError) Error() string { return e.err.Error() }
+
+var errTimeout = errors.New("conversion timed out")
+
+// Convert the uploaded files, giving up once ctx is done. Entries
+// are tagged with the names the files were uploaded as, rather than
+// where they were saved.
+func (u *uploaded) Convert(ctx context.Context) ([]byte, error) {
+	entries, err := loadContext(ctx, u.files)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, errTimeout
+		}
+		e := &convertError{layout: "unknown", err: err}
+		for path, name := range u.names {
+			if strings.Contains(err.Error(), path) {
+				e.layout = u.layouts[path]
+			}
+			e.err = errors.New(strings.Replace(e.err.Error(), path, name, -1))
+		}
+		return nil, e
+	}
+	for _, e := range entries {
+		if name, ok := u.names[e["Source"]]; ok {
+			e["Source"] = name
+		}
+	}
+
+	var b bytes.Buffer
+	switch u.format {
+	case "csv", "tsv":
+		entries = renderText(window(entries))
+	}
+	switch u.format {
+	case "csv":
+		err = writeCSV(&b, columns(entries), entries)
+	case "tsv":
+		err = writeTSV(&b, columns(entries), entries)
+	case "ndjson":
+		enc := json.NewEncoder(&b)
+		for _, e := range render(window(entries)) {
+			if err = enc.Encode(e); err != nil {
+				break
+			}
+		}
+	default:
+		err = emit(&b, entries, nil)
+	}
+	return b.Bytes(), err
+}
+
+// The columns of entries: -fields, or else all their keys.
+func columns(entries []map[string]string) []string {
+	if keys := fields(); keys != nil {
+		return keys
+	}
+	seen := make(map[string]bool)
+	var keys []string
+	for _, e := range entries {
+		for k := range e {
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Convert the uploaded files within -timeout, recording metrics,
+// and then remove them and give up the conversion's slot. The
+// clock starts once the conversion has its turn to load; one that
+// runs out of time is cancelled.
+func (s *server) run(u *uploaded) ([]byte, error) {
+	defer s.release()
+	defer u.Close()
+	loading.Lock()
+	defer loading.Unlock()
+	inputs = nil
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeoutFlag)
+	defer cancel()
+	start := time.Now()
+	out, err := u.Convert(ctx)
+	s.metrics.record(time.Since(start), err)
+	return out, err
+}
+
+// Take a slot for a conversion, reporting whether one was free.
+func (s *server) acquire() bool {
+	select {
+	case s.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *server) release() { <-s.slots }
+
+func (m *metrics) record(d time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.parses++
+	switch e := err.(type) {
+	case nil:
+	case *convertError:
+		m.failures[e.layout]++
+	default:
+		if err == errTimeout {
+			m.timeouts++
+		} else {
+			m.failures["unknown"]++
+		}
+	}
+	secs := d.Seconds()
+	for i, le := range latencyBuckets {
+		if secs <= le {
+			m.buckets[i]++
+		}
+	}
+	m.count++
+	m.sum += secs
+}
+
+func (s *server) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	m := &s.metrics
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	p := func(format string, args ...interface{}) {
+		fmt.Fprintf(w, format, args...)
+	}
+
+	p("# HELP eac2json_parses_total Conversions attempted.\n")
+	p("# TYPE eac2json_parses_total counter\n")
+	p("eac2json_parses_total %d\n", m.parses)
+
+	p("# HELP eac2json_failures_total Conversions failed, by the layout of the failing file.\n")
+	p("# TYPE eac2json_failures_total counter\n")
+	var layouts []string
+	for l := range m.failures {
+		layouts = append(layouts, l)
+	}
+	sort.Strings(layouts)
+	for _, l := range layouts {
+		p("eac2json_failures_total{layout=%q} %d\n", l, m.failures[l])
+	}
+
+	p("# HELP eac2json_timeouts_total Conversions cancelled after -timeout.\n")
+	p("# TYPE eac2json_timeouts_total counter\n")
+	p("eac2json_timeouts_total %d\n", m.timeouts)
+
+	p("# HELP eac2json_parse_seconds Conversion latency.\n")
+	p("# TYPE eac2json_parse_seconds histogram\n")
+	for i, le := range latencyBuckets {
+		p("eac2json_parse_seconds_bucket{le=\"%g\"} %d\n", le, m.buckets[i])
+	}
+	p("eac2json_parse_seconds_bucket{le=\"+Inf\"} %d\n", m.count)
+	p("eac2json_parse_seconds_sum %g\n", m.sum)
+	p("eac2json_parse_seconds_count %d\n", m.count)
+}
+
+func uploadError(w http.ResponseWriter, err error) {
+	var big *http.MaxBytesError
+	if errors.As(err, &big) {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
+
+func (s *server) convert(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.acquire() {
+		http.Error(w, "too many conversions", http.StatusServiceUnavailable)
+		return
+	}
+	u, err := upload(r)
+	if err != nil {
+		s.release()
+		uploadError(w, err)
+		return
+	}
+
+	out, err := s.run(u)
+	switch {
+	case err == errTimeout:
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	w.Header().Set("Content-Type", formats[u.format])
+	w.Write(out)
+}
+
+func (s *server) submit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.acquire() {
+		http.Error(w, "too many conversions", http.StatusServiceUnavailable)
+		return
+	}
+	u, err := upload(r)
+	if err != nil {
+		s.release()
+		uploadError(w, err)
+		return
+	}
+
+	b := make([]byte, 8)
+	rand.Read(b)
+	j := &job{ID: hex.EncodeToString(b), Status: "pending", Format: u.format}
+	s.mu.Lock()
+	s.expire()
+	s.jobs[j.ID] = j
+	s.mu.Unlock()
+
+	go func() {
+		s.update(j, func() { j.Status = "running" })
+		out, err := s.run(u)
+		s.update(j, func() {
+			if err != nil {
+				j.Status, j.Error = "failed", err.Error()
+			} else {
+				j.Status, j.result = "done", out
+			}
+			j.Done = time.Now()
+		})
+	}()
+
+	w.Header().Set("Location", "/jobs/"+j.ID)
+	w.WriteHeader(http.StatusAccepted)
+	s.writeJob(w, j)
+}
+
+func (s *server) job(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "GET only", http.StatusMethodNotAllowed)
+		return
+	}
+	id, result := strings.TrimPrefix(r.URL.Path, "/jobs/"), false
+	if strings.HasSuffix(id, "/result") {
+		id, result = strings.TrimSuffix(id, "/result"), true
+	}
+
+	s.mu.Lock()
+	j, ok := s.jobs[id]
+	s.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if !result {
+		s.writeJob(w, j)
+		return
+	}
+
+	s.mu.Lock()
+	status, out := j.Status, j.result
+	s.mu.Unlock()
+	if status != "done" {
+		http.Error(w, "job is "+status, http.StatusConflict)
+		return
+	}
+	w.Header().Set("Content-Type", formats[j.Format])
+	w.Write(out)
+}
+
+func (s *server) update(j *job, f func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f()
+}
+
+func (s *server) writeJob(w http.ResponseWriter, j *job) {
+	s.mu.Lock()
+	b, err := json.Marshal(j)
+	s.mu.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(append(b, '\n'))
+}
+
+// Forget jobs finished more than jobTTL ago. Called with s.mu held.
+func (s *server) expire() {
+	for id, j := range s.jobs {
+		if !j.Done.IsZero() && time.Since(j.Done) > jobTTL {
+			delete(s.jobs, id)
+		}
+	}
+}
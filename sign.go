@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Read the PEM block in file.
+func readPEM(file string) (*pem.Block, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data", file)
+	}
+	return block, nil
+}
+
+// Read a PKCS #8 private key (Ed25519, ECDSA, or RSA) from file.
+func readSigner(file string) (crypto.Signer, error) {
+	block, err := readPEM(file)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	s, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%s: not a signing key", file)
+	}
+	return s, nil
+}
+
+// Read a public key from file, which may also hold the private key.
+func readPublicKey(file string) (crypto.PublicKey, error) {
+	block, err := readPEM(file)
+	if err != nil {
+		return nil, err
+	}
+	if strings.Contains(block.Type, "PRIVATE") {
+		s, err := readSigner(file)
+		if err != nil {
+			return nil, err
+		}
+		return s.Public(), nil
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	return key, nil
+}
+
+// Sign data: Ed25519 keys sign it directly; others sign its
+// SHA-256 digest.
+func sign(s crypto.Signer, data []byte) ([]byte, error) {
+	if _, ok := s.Public().(ed25519.PublicKey); ok {
+		return s.Sign(rand.Reader, data, crypto.Hash(0))
+	}
+	sum := sha256.Sum256(data)
+	return s.Sign(rand.Reader, sum[:], crypto.SHA256)
+}
+
+func verify(pub crypto.PublicKey, data, sig []byte) error {
+	sum := sha256.Sum256(data)
+	ok := false
+	switch pub := pub.(type) {
+	case ed25519.PublicKey:
+		ok = ed25519.Verify(pub, data, sig)
+	case *ecdsa.PublicKey:
+		ok = ecdsa.VerifyASN1(pub, sum[:], sig)
+	case *rsa.PublicKey:
+		ok = rsa.VerifyPKCS1v15(pub, crypto.SHA256, sum[:], sig) == nil
+	default:
+		return errors.New("unsupported key type")
+	}
+	if !ok {
+		return errors.New("bad signature")
+	}
+	return nil
+}
+
+// The key given by -sign, read before the command runs.
+var signer crypto.Signer
+
+// Sign data with signer, writing the signature, in base64, to
+// -signature.
+func writeSignature(data []byte) error {
+	sig, err := sign(signer, data)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(*signatureFlag, []byte(base64.StdEncoding.EncodeToString(sig)+"\n"), 0644)
+}
+
+// Verify the signature of an output file, as written with -sign.
+func verifyCommand(args []string) error {
+	if len(args) != 3 {
+		return errors.New("usage: eac2json verify key.pem file signature")
+	}
+	pub, err := readPublicKey(args[0])
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(args[1])
+	if err != nil {
+		return err
+	}
+	b, err := os.ReadFile(args[2])
+	if err != nil {
+		return err
+	}
+	sig, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(b)))
+	if err != nil {
+		return fmt.Errorf("%s: %s", args[2], err)
+	}
+	if err := verify(pub, data, sig); err != nil {
+		return fmt.Errorf("%s: %s", args[1], err)
+	}
+	fmt.Printf("%s: ok\n", args[1])
+	return nil
+}
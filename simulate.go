@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A sale planned for simulate.
+type plannedSale struct {
+	shares Decimal
+	symbol string
+	date   time.Time
+	price  Decimal
+}
+
+// The sales given by -sell.
+type plannedSales []plannedSale
+
+var planned plannedSales
+
+func (p *plannedSales) String() string { return "" }
+
+// Set parses a planned sale as "shares symbol @ date @ price".
+func (p *plannedSales) Set(s string) error {
+	parts := strings.Split(s, "@")
+	if len(parts) != 3 {
+		return errors.New("sale must be shares symbol @ date @ price")
+	}
+	what := strings.Fields(parts[0])
+	if len(what) != 2 {
+		return errors.New("sale must be shares symbol @ date @ price")
+	}
+	var (
+		ps  = plannedSale{symbol: what[1]}
+		err error
+	)
+	if ps.shares, err = parseDecimal(what[0]); err != nil {
+		return err
+	}
+	if ps.date, err = parseDate(strings.TrimSpace(parts[1])); err != nil {
+		return err
+	}
+	if ps.price, err = parseDecimal(strings.TrimSpace(parts[2])); err != nil {
+		return err
+	}
+	*p = append(*p, ps)
+	return nil
+}
+
+// The columns of the simulate report.
+var simulateColumns = []string{"Date Sold", "Symbol", "Shares", "Date Acquired", "Term", "Proceeds", "Cost Basis", "Gain or Loss", "Disallowed", "Replaced By"}
+
+// Simulate the sales given by -sell after the history in the
+// files, along with the vests to come on the unvested awards pages
+// among them, reporting the lots each would sell, their term and
+// gain or loss, and the loss, if any, that would be disallowed as a
+// wash sale, with the lots that would replace the shares sold.
+//
+// Vests are taken at the price of the last planned sale of their
+// symbol, or with no basis; those not after the history are taken
+// to be in it already.
+func simulateCommand(args []string) error {
+	q, err := parseQuery(*queryFlag)
+	if err != nil {
+		return err
+	}
+	if len(planned) == 0 {
+		return errors.New(`usage: eac2json simulate -sell "shares symbol @ date @ price" [file...]`)
+	}
+	entries, err := load(args)
+	if err != nil {
+		return err
+	}
+
+	var (
+		all   []map[string]string
+		last  time.Time
+		price = make(map[string]Decimal)
+		// The owner (see owner) of the history of each symbol,
+		// to whom the vests and sales are taken to belong.
+		owners = make(map[string]string)
+	)
+	for _, ps := range planned {
+		price[ps.symbol] = ps.price
+	}
+	for _, e := range entries {
+		if e["Record"] == "unvested" {
+			continue
+		}
+		if d, err := parseDate(e["Date"]); err == nil && d.After(last) {
+			last = d
+		}
+		if buyActions[e["Action"]] {
+			owners[e["Symbol"]] = owner(e)
+		}
+		all = append(all, e)
+	}
+	for _, e := range entries {
+		d, err := parseDate(e["Vest Date"])
+		if e["Record"] != "unvested" || err != nil || !d.After(last) {
+			continue
+		}
+		all = append(all, map[string]string{
+			"Date":              formatDate(d),
+			"Action":            "Release",
+			"Symbol":            e["Symbol"],
+			"Award ID":          e["Award ID"],
+			"Shares":            e["Shares"],
+			"Fair Market Value": "$" + price[e["Symbol"]].Fixed(2),
+			"Source":            "vest",
+			"Account Name":      owners[e["Symbol"]],
+			"Seq":               strconv.Itoa(len(all) + 1),
+		})
+	}
+	for _, ps := range planned {
+		all = append(all, map[string]string{
+			"Date":         formatDate(ps.date),
+			"Action":       "Sell",
+			"Symbol":       ps.symbol,
+			"Shares":       ps.shares.String(),
+			"Sale Price":   "$" + ps.price.String(),
+			"Source":       "simulated",
+			"Account Name": owners[ps.symbol],
+			"Seq":          strconv.Itoa(len(all) + 1),
+		})
+	}
+
+	c, err := loadWashConfig(*washConfigFlag)
+	if err != nil {
+		return err
+	}
+	b := new(Book)
+	c.Configure(b)
+	if err := b.Run(all); err != nil {
+		return err
+	}
+
+	replaced := make(map[*Sale][]string)
+	for _, w := range b.Washes {
+		r := fmt.Sprintf("%s sh. %s", w.Shares, formatDate(w.Replacement.Acquired))
+		if w.Replacement.Source == "vest" {
+			r += " (vest)"
+		}
+		replaced[w.Sale] = append(replaced[w.Sale], r)
+	}
+	var records []map[string]string
+	for _, s := range b.Sales {
+		if s.Source != "simulated" {
+			continue
+		}
+		term := "Short-term"
+		if s.Long() {
+			term = "Long-term"
+		}
+		records = append(records, map[string]string{
+			"Date Sold":     formatDate(s.Sold),
+			"Symbol":        s.Symbol,
+			"Shares":        s.Shares.String(),
+			"Date Acquired": formatDate(s.Acquired),
+			"Term":          term,
+			"Proceeds":      s.Proceeds.Fixed(2),
+			"Cost Basis":    s.Basis.Fixed(2),
+			"Gain or Loss":  s.Gain().Fixed(2),
+			"Disallowed":    s.Disallowed.Fixed(2),
+			"Replaced By":   strings.Join(replaced[s], ", "),
+		})
+	}
+	return report(os.Stdout, simulateColumns, records, q)
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Sniff the kind of a source from its first bytes: "html",
+// "mhtml", "json" (our own entries), "equity-json" (the Equity
+// Awards site's export, or our own entries in an envelope), "csv",
+// "xlsx", "pdf", or "text", or the name of a plan administrator
+// whose export it is (see plans).
+func sniff(br *bufio.Reader) (string, error) {
+	for {
+		c, _, err := br.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		if !unicode.IsSpace(c) && c != '\ufeff' {
+			br.UnreadRune()
+			break
+		}
+	}
+
+	b, _ := br.Peek(512)
+	head := string(b)
+	line := head
+	if i := strings.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+	lower := strings.ToLower(head)
+	p := sniffPlan(head)
+
+	switch {
+	case strings.HasPrefix(head, "%PDF"):
+		return "pdf", nil
+	case strings.HasPrefix(head, "PK\x03\x04"):
+		return "xlsx", nil
+	case strings.HasPrefix(head, "["):
+		return "json", nil
+	case strings.HasPrefix(head, "{"):
+		return "equity-json", nil
+	case strings.HasPrefix(head, "<"):
+		return "html", nil
+	case strings.HasPrefix(lower, "from:") || strings.HasPrefix(lower, "mime-version:") ||
+		strings.Contains(lower, "multipart/related"):
+		return "mhtml", nil
+	case p != nil:
+		return p.name, nil
+	case strings.Contains(line, ",") && strings.Contains(line, "Date") && strings.Contains(line, "Action"):
+		return "csv", nil
+	}
+	return "text", nil
+}
+
+// Read entries from a source of any kind (see sniff): a saved EAC
+// page, as HTML or MHTML; a history exported by Schwab as CSV, XLSX,
+// or JSON; our own output, e.g. from an earlier run, as a JSON array
+// or envelope (see readArchive), or as NDJSON (see -append), or a
+// brokerage history converted by other means; the export of another
+// plan administrator (see plans); or the text of an EAC statement,
+// as extracted from its PDF. The frames of a page are looked for in
+// dir, if given. The kind may be given by -source, as may an
+// arbitrary CSV export, as "generic", with its -mapping (see
+// mapping).
+func readSource(r io.Reader, dir string, rules []Rule) ([]map[string]string, error) {
+	br := bufio.NewReader(r)
+	kind, err := sniff(br)
+	if err != nil {
+		return nil, err
+	}
+	if *sourceFlag != "" {
+		kind = *sourceFlag
+	}
+
+	switch kind {
+	case "pdf":
+		return nil, errors.New("PDF statements must first be converted to text, e.g. with pdftotext -layout")
+	case "xlsx":
+		all, err := io.ReadAll(br)
+		if err != nil {
+			return nil, err
+		}
+		return parseExportXLSX(all)
+	case "csv":
+		return parseExportCSV(br)
+	case "equity-json":
+		b, err := io.ReadAll(br)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case isEnvelope(b):
+			return readArchive(b)
+		case isNDJSON(b):
+			return parseNDJSON(bytes.NewReader(b))
+		}
+		return parseEquityJSON(bytes.NewReader(b))
+	case "html":
+		return parse(br, rules, fileFrames(dir))
+	case "mhtml":
+		h, frames, err := mhtmlDocument(br)
+		if err != nil {
+			return nil, err
+		}
+		return parse(h, rules, frames)
+	case "text":
+		return parseStatement(br)
+	case "generic":
+		p, err := readMapping(*mappingFlag)
+		if err != nil {
+			return nil, err
+		}
+		return p.Parse(br)
+	}
+	if p := planNamed(kind); p != nil {
+		return p.Parse(br)
+	}
+
+	b, err := io.ReadAll(br)
+	if err != nil {
+		return nil, err
+	}
+	return readArchive(b)
+}
+
+// Convert a decoded JSON object to strings.
+func stringMap(m map[string]interface{}) map[string]string {
+	e := make(map[string]string)
+	for k, v := range m {
+		switch v := v.(type) {
+		case nil:
+			e[k] = ""
+		case string:
+			e[k] = v
+		default:
+			e[k] = fmt.Sprint(v)
+		}
+	}
+	return e
+}
+
+// Find the HTML document in a page saved as MHTML: its first HTML
+// part. The others are its frames.
+func mhtmlDocument(r io.Reader) (io.Reader, frameFunc, error) {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Parts in quoted-printable are decoded by the reader.
+	var (
+		doc   []byte
+		base  string
+		parts = make(map[string][]byte)
+	)
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if !strings.HasPrefix(p.Header.Get("Content-Type"), "text/html") {
+			continue
+		}
+		var body io.Reader = p
+		if strings.EqualFold(p.Header.Get("Content-Transfer-Encoding"), "base64") {
+			body = base64.NewDecoder(base64.StdEncoding, p)
+		}
+		b, err := io.ReadAll(body)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		loc := p.Header.Get("Content-Location")
+		if doc == nil {
+			doc, base = b, loc
+			continue
+		}
+		if loc != "" {
+			parts[loc] = b
+		}
+		if id := strings.Trim(p.Header.Get("Content-ID"), "<>"); id != "" {
+			parts["cid:"+id] = b
+		}
+	}
+	if doc == nil {
+		return nil, nil, errors.New("no HTML in MHTML")
+	}
+	return bytes.NewReader(doc), mhtmlFrames(base, parts), nil
+}
+
+// Parse the JSON history exported by the Equity Awards site. It
+// is an object with a list of "Transactions", each of which may
+// have "TransactionDetails" with their "Details"; these are
+// treated like the rows of the CSV export.
+func parseEquityJSON(r io.Reader) ([]map[string]string, error) {
+	var doc struct {
+		Transactions []map[string]interface{}
+	}
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	var entries []map[string]string
+	for _, t := range doc.Transactions {
+		var details []map[string]string
+		if ds, ok := t["TransactionDetails"].([]interface{}); ok {
+			for _, d := range ds {
+				d, _ := d.(map[string]interface{})
+				if m, ok := d["Details"].(map[string]interface{}); ok {
+					details = append(details, exportMap(stringMap(m)))
+				}
+			}
+		}
+		delete(t, "TransactionDetails")
+		entries = append(entries, exportTransaction(exportMap(stringMap(t)), details)...)
+	}
+	return entries, nil
+}
+
+// A ctxReader fails once its context is done, so that reading a
+// source may be given up.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// Load the entries from the named files, or standard input if
+// none are given. With more than one file, entries are tagged with
+// the file they came from as their "Source", unless they already
+// have one, and with their place among its entries, counting from
+// 1, as their "Source Row". This is not a row of the file: a page's
+// details, say, are no entries of their own. With -prefer,
+// transactions the files have in common are resolved (see
+// resolveOverlaps). With -as-of, entries dated after it are
+// dropped. With -account, entries are tagged with it as their
+// "Account Name", in preference to any inferred from the page.
+// Entries are numbered in the order given, as their "Seq", which
+// orders entries on the same day. Each entry is given an ID (see
+// identify), corrections are linked to the entries they correct,
+// cash in lieu of fractional shares is picked out of the details,
+// exercise confirmations are joined onto their entries, the
+// corrections in -overlay are applied, the entries listed by
+// -exclude-ids are dropped, and missing prices are filled in from
+// -prices.
+func load(files []string) ([]map[string]string, error) {
+	return loadContext(context.Background(), files)
+}
+
+// Load the entries as load does, giving up once ctx is done.
+func loadContext(ctx context.Context, files []string) ([]map[string]string, error) {
+	rules, err := loadRules(*rulesFlag)
+	if err != nil {
+		return nil, err
+	}
+	rulesHash = hashRules(rules)
+
+	var all []map[string]string
+	if len(files) == 0 {
+		r := newHashReader(os.Stdin, "-")
+		if all, err = readSource(r, "", rules); err != nil {
+			return nil, err
+		}
+		if err := r.Done(); err != nil {
+			return nil, err
+		}
+	}
+	var from []int // the index of the file of each entry
+	for fi, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		r := newHashReader(ctxReader{ctx, f}, file)
+		entries, err := readSource(r, filepath.Dir(file), rules)
+		if err == nil {
+			err = r.Done()
+		}
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", file, err)
+		}
+
+		if len(files) > 1 {
+			for i, e := range entries {
+				if e["Source"] == "" {
+					e["Source"] = file
+					e["Source Row"] = strconv.Itoa(i + 1)
+				}
+			}
+		}
+		all = append(all, entries...)
+		for range entries {
+			from = append(from, fi)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if *preferFlag != "" && len(files) > 1 {
+		if all, err = resolveOverlaps(all, files, from); err != nil {
+			return nil, err
+		}
+	}
+	if *asOfFlag != "" {
+		if all, err = asOf(all, *asOfFlag); err != nil {
+			return nil, err
+		}
+	}
+	number(all)
+	identify(all)
+	linkCorrections(all)
+	cashInLieu(all)
+	if *accountFlag != "" {
+		for _, e := range all {
+			e["Account Name"] = *accountFlag
+		}
+	}
+	all = joinExercises(all)
+	if *overlayFlag != "" {
+		o, err := readOverlay(*overlayFlag)
+		if err != nil {
+			return nil, err
+		}
+		all = o.Apply(all)
+	}
+	if *excludeFlag != "" {
+		o, err := readExcludes(*excludeFlag)
+		if err != nil {
+			return nil, err
+		}
+		all = o.Apply(all)
+	}
+
+	if *pricesFlag != "" {
+		p, err := priceProvider(*pricesFlag)
+		if err != nil {
+			return nil, err
+		}
+		if err := fillPrices(all, p); err != nil {
+			return nil, err
+		}
+	}
+	return all, nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The SQL schemas, by -schema. The plain schema keeps each entry
+// as JSON, by ID. The analytics schema, for Datasette and
+// notebooks, also gives the common keys as typed columns, with
+// dates in ISO form, indexed by date, symbol and action, and has
+// views of the vests and sales.
+var sqlSchemas = map[string]string{
+	"plain": `CREATE TABLE IF NOT EXISTS entries (
+	id TEXT PRIMARY KEY,
+	seq INTEGER,
+	entry TEXT -- JSON
+);
+`,
+	"analytics": `CREATE TABLE IF NOT EXISTS entries (
+	id TEXT PRIMARY KEY,
+	seq INTEGER,
+	date TEXT, -- YYYY-MM-DD
+	action TEXT,
+	symbol TEXT,
+	award_id TEXT,
+	type TEXT,
+	shares REAL,
+	net_shares REAL,
+	price REAL,
+	fmv REAL,
+	amount REAL,
+	fees REAL,
+	taxes REAL,
+	account TEXT,
+	source TEXT,
+	entry TEXT -- JSON
+);
+CREATE INDEX IF NOT EXISTS entries_date ON entries (date);
+CREATE INDEX IF NOT EXISTS entries_symbol ON entries (symbol);
+CREATE INDEX IF NOT EXISTS entries_action ON entries (action);
+CREATE VIEW IF NOT EXISTS vests AS
+	SELECT id, date, symbol, award_id, shares, net_shares, fmv,
+		shares * fmv AS income, taxes
+	FROM entries WHERE action IN (%s);
+CREATE VIEW IF NOT EXISTS sales AS
+	SELECT id, date, symbol, award_id, action, shares, price,
+		shares * price AS gross, fees, amount
+	FROM entries WHERE action IN (%s);
+`,
+}
+
+// The typed columns of the analytics schema, and the keys they are
+// taken from, in order of preference.
+var sqlColumns = []struct {
+	column string
+	keys   []string
+}{
+	{"shares", []string{"Shares", "Quantity"}},
+	{"net_shares", []string{"Net Shares Deposited"}},
+	{"price", []string{"Sale Price", "Price", "Purchase Price", "Award Price"}},
+	{"fmv", []string{"Fair Market Value", "FMV", "Purchase FMV"}},
+	{"amount", []string{"Amount"}},
+	{"fees", []string{"Fees & Commissions"}},
+	{"taxes", []string{"Taxes"}},
+}
+
+// Write the entries as an SQL script in the -schema, e.g. for
+// sqlite3 awards.db. Entries already in the database, by ID, are
+// left alone, so the script may be run again on later histories.
+func sqlCommand(args []string) error {
+	entries, err := load(args)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(os.Stdout)
+	fmt.Fprintf(w, "BEGIN;\n")
+	if *sqlSchemaFlag == "analytics" {
+		fmt.Fprintf(w, sqlSchemas["analytics"], sqlList(lapseActions), sqlList(sellActions, saleActions))
+	} else {
+		fmt.Fprint(w, sqlSchemas["plain"])
+	}
+
+	for _, e := range window(entries) {
+		b, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		seq := "NULL"
+		if n, err := strconv.Atoi(e["Seq"]); err == nil {
+			seq = strconv.Itoa(n)
+		}
+		if *sqlSchemaFlag != "analytics" {
+			fmt.Fprintf(w, "INSERT OR IGNORE INTO entries (id, seq, entry) VALUES (%s, %s, %s);\n",
+				sqlQuote(e["ID"]), seq, sqlQuote(string(b)))
+			continue
+		}
+
+		date := "NULL"
+		if d, err := parseDate(e["Date"]); err == nil {
+			date = sqlQuote(d.Format("2006-01-02"))
+		}
+		cols := []string{"id", "seq", "date", "action", "symbol", "award_id", "type"}
+		vals := []string{sqlQuote(e["ID"]), seq, date, sqlQuote(e["Action"]), sqlQuote(e["Symbol"]), sqlQuote(grant(e)), sqlQuote(e["Type"])}
+		for _, c := range sqlColumns {
+			v := "NULL"
+			if d, ok := first(e, c.keys); ok {
+				v = d.String()
+			}
+			cols = append(cols, c.column)
+			vals = append(vals, v)
+		}
+		cols = append(cols, "account", "source", "entry")
+		vals = append(vals, sqlQuote(e["Account Name"]), sqlQuote(e["Source"]), sqlQuote(string(b)))
+		fmt.Fprintf(w, "INSERT OR IGNORE INTO entries (%s) VALUES (%s);\n", strings.Join(cols, ", "), strings.Join(vals, ", "))
+	}
+	fmt.Fprintf(w, "COMMIT;\n")
+	return w.Flush()
+}
+
+func sqlQuote(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+// The actions of the sets, as an SQL list.
+func sqlList(sets ...map[string]bool) string {
+	var l []string
+	for _, set := range sets {
+		for a := range set {
+			l = append(l, sqlQuote(a))
+		}
+	}
+	sort.Strings(l)
+	return strings.Join(l, ", ")
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// The client for fetches, which gives up on servers that stop
+// responding.
+var httpClient = &http.Client{Timeout: time.Minute}
+
+// A statement listed on the "Statements" sub-tab.
+type statement struct {
+	Period     string
+	DocumentID string
+	URL        string
+}
+
+// Find the statements listed on a saved Statements page: links
+// to PDF documents, each in a table row whose first cell gives
+// the period.
+func parseStatements(doc *html.Node) []statement {
+	var base *url.URL
+	var stmts []statement
+	var walk func(n, tr *html.Node)
+	walk = func(n, tr *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.Data {
+			case "base":
+				base, _ = url.Parse(attr(n, "href"))
+			case "tr":
+				tr = n
+			case "a":
+				if s, ok := statementLink(n, tr, base); ok {
+					stmts = append(stmts, s)
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c, tr)
+		}
+	}
+	walk(doc, nil)
+	return stmts
+}
+
+// The host a saved page came from, as given by its base URL, or by
+// the "saved from url" comment browsers leave; "" if unknown.
+func pageHost(n *html.Node) string {
+	switch {
+	case n.Type == html.ElementNode && n.Data == "base":
+		if u, err := url.Parse(attr(n, "href")); err == nil && u.Host != "" {
+			return u.Hostname()
+		}
+	case n.Type == html.CommentNode:
+		const saved = "saved from url="
+		if i := strings.Index(n.Data, saved); i >= 0 {
+			s := strings.TrimSpace(n.Data[i+len(saved):])
+			if j := strings.Index(s, ")"); strings.HasPrefix(s, "(") && j >= 0 {
+				s = s[j+1:]
+			}
+			if u, err := url.Parse(strings.TrimSpace(s)); err == nil && u.Host != "" {
+				return u.Hostname()
+			}
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if h := pageHost(c); h != "" {
+			return h
+		}
+	}
+	return ""
+}
+
+// Whether statements may be fetched from host, with the session's
+// cookie: it must be Schwab's, or the page's own.
+func statementHost(host, page string) bool {
+	host = strings.ToLower(host)
+	return host == strings.ToLower(page) && page != "" ||
+		host == "schwab.com" || strings.HasSuffix(host, ".schwab.com")
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func statementLink(a, tr *html.Node, base *url.URL) (statement, bool) {
+	u, err := url.Parse(attr(a, "href"))
+	if err != nil || u.String() == "" {
+		return statement{}, false
+	}
+
+	// The document ID is given by a query parameter, or else
+	// is the PDF's file name.
+	var id string
+	for k, v := range u.Query() {
+		if strings.Contains(strings.ToLower(k), "doc") && len(v) > 0 {
+			id = v[0]
+		}
+	}
+	if id == "" {
+		if !strings.HasSuffix(strings.ToLower(u.Path), ".pdf") {
+			return statement{}, false
+		}
+		id = strings.TrimSuffix(filepath.Base(u.Path), filepath.Ext(u.Path))
+	}
+
+	if base != nil {
+		u = base.ResolveReference(u)
+	}
+
+	period := textContent(a)
+	if tr != nil {
+		for c := tr.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.ElementNode && c.Data == "td" {
+				period = textContent(c)
+				break
+			}
+		}
+	}
+	return statement{period, id, u.String()}, true
+}
+
+// Write a manifest of the statements listed on a saved Statements
+// page and, with -fetch, download them into a directory. Downloads
+// need the session's cookie, given by -cookie; they are only made
+// from Schwab's hosts, or the page's own (see statementHost).
+func statementsCommand(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: eac2json statements [-fetch dir] [-cookie cookie] page.html")
+	}
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	doc, err := html.Parse(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	stmts := parseStatements(doc)
+	if len(stmts) == 0 {
+		return errors.New("no statements")
+	}
+
+	if *fetchFlag != "" {
+		host := pageHost(doc)
+		for _, s := range stmts {
+			if err := fetchStatement(s, *fetchFlag, host); err != nil {
+				return err
+			}
+		}
+	}
+
+	var records []map[string]string
+	for _, s := range stmts {
+		records = append(records, map[string]string{
+			"Period":      s.Period,
+			"Document ID": s.DocumentID,
+			"URL":         s.URL,
+		})
+	}
+	q, err := parseQuery(*queryFlag)
+	if err != nil {
+		return err
+	}
+	return emit(os.Stdout, records, q)
+}
+
+func fetchStatement(s statement, dir, host string) error {
+	// The ID names the file it's saved in, so it must not name one
+	// elsewhere.
+	if s.DocumentID == "" || s.DocumentID == "." || s.DocumentID == ".." || strings.ContainsAny(s.DocumentID, `/\`) {
+		return fmt.Errorf("statement %q: bad document ID", s.DocumentID)
+	}
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		return err
+	}
+	if !u.IsAbs() {
+		return fmt.Errorf("statement %s: relative URL %s", s.DocumentID, s.URL)
+	}
+	if u.Scheme != "https" && u.Scheme != "http" || !statementHost(u.Hostname(), host) {
+		return fmt.Errorf("statement %s: not fetching from %s", s.DocumentID, u.Host)
+	}
+
+	req, err := http.NewRequest("GET", s.URL, nil)
+	if err != nil {
+		return err
+	}
+	if *cookieFlag != "" {
+		req.Header.Set("Cookie", *cookieFlag)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("statement %s: %s", s.DocumentID, resp.Status)
+	}
+
+	out, err := os.Create(filepath.Join(dir, s.DocumentID+".pdf"))
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, resp.Body); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
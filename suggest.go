@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+)
+
+// Long-term gains are taxed at roughly half the rate of short-term
+// gains; this is only used to rank lots.
+var longTermWeight = Decimal{big.NewRat(1, 2)}
+
+// The lots commands.
+func lotsCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: eac2json lots export|import|suggest-sale|donate ...")
+	}
+	cmd, args := args[0], args[1:]
+	flag.CommandLine.Parse(args)
+	switch cmd {
+	case "export":
+		return exportLots(flag.Args())
+	case "import":
+		return importLots(flag.Args())
+	case "suggest-sale":
+		return suggestSale(flag.Args())
+	case "donate":
+		return suggestDonation(flag.Args())
+	}
+	return fmt.Errorf("unknown lots command %q", cmd)
+}
+
+type candidate struct {
+	lot    *Lot
+	shares Decimal
+	cost   Decimal // per share
+	long   bool
+	wash   bool
+	score  Decimal
+}
+
+// Suggest which open lots to specify when selling -shares of
+// -symbol on -date (by default, today) at -price, so as to
+// minimize tax: losses first, unless they'd be washed by shares
+// acquired within the wash sale window, then the smallest gains,
+// preferring long-term ones. Without a price, lots with the
+// highest basis are suggested first.
+func suggestSale(args []string) error {
+	q, err := parseQuery(*queryFlag)
+	if err != nil {
+		return err
+	}
+	if *symbolFlag == "" || *sharesFlag == "" {
+		return errors.New("suggest-sale needs -symbol and -shares")
+	}
+	want, err := parseDecimal(*sharesFlag)
+	if err != nil {
+		return err
+	}
+	date, err := flagDate()
+	if err != nil {
+		return err
+	}
+	var price Decimal
+	havePrice := *priceFlag != ""
+	if havePrice {
+		if price, err = parseDecimal(*priceFlag); err != nil {
+			return err
+		}
+	}
+
+	b, err := book(args)
+	if err != nil {
+		return err
+	}
+
+	var (
+		cands []candidate
+		held  Decimal
+	)
+	lo, hi := date.AddDate(0, 0, -b.Window), date.AddDate(0, 0, b.Window)
+	for _, l := range b.Lots {
+		if l.Open.Sign() == 0 || l.Symbol != *symbolFlag || l.Account != "brokerage" {
+			continue
+		}
+		c := candidate{lot: l, cost: l.Basis.Quo(l.Open)}
+		c.long = date.After(l.Since.AddDate(1, 0, 0))
+		if !havePrice {
+			c.score = c.cost.Neg()
+		} else if gain := price.Sub(c.cost); gain.Sign() < 0 {
+			// A loss is only worth taking if it isn't washed by
+			// other shares acquired within the window.
+			for _, r := range b.Lots {
+				if r != l && b.Identical(r.Symbol, l.Symbol) && !r.Acquired.Before(lo) && !r.Acquired.After(hi) {
+					c.wash = true
+				}
+			}
+			if !c.wash {
+				c.score = gain
+			}
+		} else if c.long {
+			c.score = gain.Mul(longTermWeight)
+		} else {
+			c.score = gain
+		}
+		cands = append(cands, c)
+		held = held.Add(l.Open)
+	}
+	if held.Cmp(want) < 0 {
+		return fmt.Errorf("only %s shares of %s held", held, *symbolFlag)
+	}
+
+	sort.SliceStable(cands, func(i, j int) bool {
+		return cands[i].score.Cmp(cands[j].score) < 0
+	})
+
+	var records []map[string]string
+	for _, c := range cands {
+		if want.Sign() == 0 {
+			break
+		}
+		n := c.lot.Open
+		if n.Cmp(want) > 0 {
+			n = want
+		}
+		want = want.Sub(n)
+
+		term := "Short-term"
+		if c.long {
+			term = "Long-term"
+		}
+		r := map[string]string{
+			"Date Acquired":   formatDate(c.lot.Acquired),
+			"Holding Since":   formatDate(c.lot.Since),
+			"Source":          c.lot.Source,
+			"Symbol":          c.lot.Symbol,
+			"Shares":          n.String(),
+			"Basis Per Share": c.cost.Fixed(4),
+			"Term":            term,
+		}
+		if havePrice {
+			r["Estimated Gain"] = price.Sub(c.cost).Mul(n).Fixed(2)
+		}
+		if c.wash {
+			r["Wash Sale Risk"] = "yes"
+		}
+		records = append(records, r)
+	}
+	return emit(os.Stdout, records, q)
+}
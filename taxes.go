@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// Whether a sale is long-term: the shares were held for more
+// than a year.
+func (s *Sale) Long() bool {
+	return s.Sold.After(s.Acquired.AddDate(1, 0, 0))
+}
+
+// The sales made in the year selected by -year, or all sales.
+func (b *Book) Year() []*Sale {
+	if *yearFlag == 0 {
+		return b.Sales
+	}
+	var sales []*Sale
+	for _, s := range b.Sales {
+		if s.Sold.Year() == *yearFlag {
+			sales = append(sales, s)
+		}
+	}
+	return sales
+}
+
+// A Form 8949 row, in whole cents.
+type row8949 struct {
+	sale                              *Sale
+	proceeds, basis, adjustment, gain Decimal
+	code                              string
+}
+
+func form8949(sales []*Sale) []row8949 {
+	rows := make([]row8949, len(sales))
+	for i, s := range sales {
+		r := row8949{sale: s}
+		r.proceeds, _ = parseDecimal(s.Proceeds.Fixed(2))
+		r.basis, _ = parseDecimal(s.Basis.Fixed(2))
+		if s.Disallowed.Sign() != 0 {
+			r.code = "W"
+			r.adjustment, _ = parseDecimal(s.Disallowed.Fixed(2))
+		}
+		r.gain = r.proceeds.Sub(r.basis).Add(r.adjustment)
+		rows[i] = r
+	}
+	return rows
+}
+
+// Report Form 8949 rows, one for each lot sold.
+func form8949Command(args []string) error {
+	q, err := parseQuery(*queryFlag)
+	if err != nil {
+		return err
+	}
+	records, err := gains(args)
+	if err != nil {
+		return err
+	}
+	return emit(os.Stdout, records, q)
+}
+
+// Schedule D totals for short- or long-term sales. We can't tell
+// from the history whether basis was reported to the IRS, so these
+// are given as lines 1b and 8b (Form 8949 boxes A and D).
+type scheduleD struct {
+	line                              string
+	proceeds, basis, adjustment, gain Decimal
+}
+
+// Roll the Form 8949 rows into Schedule D totals, cross-footing
+// them against the Form 8949 output: its columns, parsed back and
+// totalled by term, must match the lines, and the gain column must
+// equal proceeds less basis plus adjustments.
+func schedule(rows []row8949) ([]*scheduleD, error) {
+	short := &scheduleD{line: "1b"}
+	long := &scheduleD{line: "8b"}
+	for _, r := range rows {
+		d := short
+		if r.sale.Long() {
+			d = long
+		}
+		d.proceeds = d.proceeds.Add(r.proceeds)
+		d.basis = d.basis.Add(r.basis)
+		d.adjustment = d.adjustment.Add(r.adjustment)
+		d.gain = d.gain.Add(r.gain)
+	}
+
+	totals := map[string]*scheduleD{
+		"Short-term": {line: short.line},
+		"Long-term":  {line: long.line},
+	}
+	for _, r := range gainRecords(rows) {
+		t := totals[r["Term"]]
+		if t == nil {
+			return nil, fmt.Errorf("form 8949: bad term %q", r["Term"])
+		}
+		for k, v := range map[string]*Decimal{
+			"Proceeds":     &t.proceeds,
+			"Cost Basis":   &t.basis,
+			"Adjustment":   &t.adjustment,
+			"Gain or Loss": &t.gain,
+		} {
+			n, err := parseDecimal(r[k])
+			if err != nil {
+				return nil, fmt.Errorf("form 8949: bad %s %q", k, r[k])
+			}
+			*v = v.Add(n)
+		}
+	}
+	for term, d := range map[string]*scheduleD{"Short-term": short, "Long-term": long} {
+		t := totals[term]
+		if t.proceeds.Cmp(d.proceeds) != 0 || t.basis.Cmp(d.basis) != 0 ||
+			t.adjustment.Cmp(d.adjustment) != 0 || t.gain.Cmp(d.gain) != 0 ||
+			t.proceeds.Sub(t.basis).Add(t.adjustment).Cmp(t.gain) != 0 {
+			return nil, fmt.Errorf("schedule D line %s does not cross-foot", d.line)
+		}
+	}
+	return []*scheduleD{short, long}, nil
+}
+
+// Report Schedule D totals.
+func scheduleDCommand(args []string) error {
+	q, err := parseQuery(*queryFlag)
+	if err != nil {
+		return err
+	}
+	b, err := book(args)
+	if err != nil {
+		return err
+	}
+	lines, err := schedule(form8949(b.Year()))
+	if err != nil {
+		return err
+	}
+
+	var records []map[string]string
+	for _, d := range lines {
+		records = append(records, map[string]string{
+			"Line":         d.line,
+			"Proceeds":     d.proceeds.Fixed(2),
+			"Cost Basis":   d.basis.Fixed(2),
+			"Adjustments":  d.adjustment.Fixed(2),
+			"Gain or Loss": d.gain.Fixed(2),
+		})
+	}
+	return emit(os.Stdout, records, q)
+}
@@ -0,0 +1,38 @@
+package main
+
+// Final statements, made on termination, word their actions
+// slightly differently: the forfeiture of unvested awards, vests
+// accelerated by the terms of a plan, and the forced exercise of
+// vested options before they expire. These are handled as the
+// actions they amount to; the action as given is kept as the
+// "Reported Action".
+var actionAliases = map[string]string{
+	"Forfeit":                    "Forfeiture",
+	"Forfeited":                  "Forfeiture",
+	"Award Forfeiture":           "Forfeiture",
+	"Termination Forfeiture":     "Forfeiture",
+	"Forfeiture of Unvested":     "Forfeiture",
+	"Termination Cancellation":   "Cancellation",
+	"Cancelled":                  "Cancellation",
+	"Expired":                    "Expiration",
+	"Accelerated Lapse":          "Lapse",
+	"Accelerated Vest":           "Release",
+	"Accelerated Release":        "Release",
+	"Forced Exercise":            "Exer and Hold",
+	"Forced Exer and Hold":       "Exer and Hold",
+	"Forced Exercise and Hold":   "Exer and Hold",
+	"Forced Exercise and Sale":   "Sale",
+	"Forced Exercise and Sell":   "Sale",
+	"Forced Exer and Sell":       "Sale",
+	"Forced Same-Day Sale":       "Sale",
+	"Forced Cashless Exercise":   "Sale",
+	"Forced Exercise (Cashless)": "Sale",
+}
+
+// Rename an aliased row's action, keeping the original.
+func unalias(row map[string]string) {
+	if a, ok := actionAliases[row["Action"]]; ok {
+		row["Reported Action"] = row["Action"]
+		row["Action"] = a
+	}
+}
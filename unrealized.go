@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"time"
+)
+
+// The columns of the unrealized report.
+var unrealizedColumns = []string{"Account", "Symbol", "Date Acquired", "Term", "Shares", "Cost Basis", "Price", "Market Value", "Unrealized Gain"}
+
+// An open lot valued at a price.
+type openLot struct {
+	lot   *Lot
+	long  bool
+	price Decimal
+	value Decimal
+	gain  Decimal
+}
+
+// The open lots, valued at the prices of -prices on date, or the
+// latest before it. Lots of symbols without a price are logged and
+// left out.
+func valueLots(b *Book, date time.Time) ([]openLot, error) {
+	p, err := priceProvider(*pricesFlag)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		lots    []openLot
+		missing = make(map[string]bool)
+	)
+	for _, l := range b.Lots {
+		if l.Open.Sign() == 0 || missing[l.Symbol] {
+			continue
+		}
+		price, ok, err := latestPrice(p, l.Symbol, date)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			log.Printf("%s: no price on or in the week before %s", l.Symbol, formatDate(date))
+			missing[l.Symbol] = true
+			continue
+		}
+		o := openLot{lot: l, price: price, long: date.After(l.Since.AddDate(1, 0, 0))}
+		o.value = l.Open.Mul(price)
+		o.gain = o.value.Sub(l.Basis)
+		lots = append(lots, o)
+	}
+	return lots, nil
+}
+
+// The price of symbol on date, or, as over weekends and holidays, on
+// the latest day of the week before it.
+func latestPrice(p PriceProvider, symbol string, date time.Time) (Decimal, bool, error) {
+	for i := 0; i < 7; i++ {
+		v, ok, err := p.Price(symbol, date.AddDate(0, 0, -i))
+		if ok || err != nil {
+			return v, ok, err
+		}
+	}
+	return Decimal{}, false, nil
+}
+
+// Report the gains, unrealized, of the open lots, valued at the
+// prices of -prices on -date (default today), followed by the
+// short- and long-term totals and their sum, as -format.
+func unrealizedCommand(args []string) error {
+	q, err := parseQuery(*queryFlag)
+	if err != nil {
+		return err
+	}
+	if *pricesFlag == "" {
+		return errors.New("usage: eac2json unrealized -prices source [-date date] [file...]")
+	}
+	date, err := flagDate()
+	if err != nil {
+		return err
+	}
+	b, err := book(args)
+	if err != nil {
+		return err
+	}
+	lots, err := valueLots(b, date)
+	if err != nil {
+		return err
+	}
+
+	var (
+		records []map[string]string
+		totals  [3]struct{ basis, value, gain Decimal }
+	)
+	for _, o := range lots {
+		term := "Short-term"
+		if o.long {
+			term = "Long-term"
+		}
+		records = append(records, map[string]string{
+			"Account":         o.lot.Account,
+			"Symbol":          o.lot.Symbol,
+			"Date Acquired":   formatDate(o.lot.Acquired),
+			"Term":            term,
+			"Shares":          o.lot.Open.String(),
+			"Cost Basis":      o.lot.Basis.Fixed(2),
+			"Price":           o.price.Fixed(2),
+			"Market Value":    o.value.Fixed(2),
+			"Unrealized Gain": o.gain.Fixed(2),
+		})
+		for _, i := range []int{0, 2} {
+			if o.long && i == 0 {
+				i = 1
+			}
+			totals[i].basis = totals[i].basis.Add(o.lot.Basis)
+			totals[i].value = totals[i].value.Add(o.value)
+			totals[i].gain = totals[i].gain.Add(o.gain)
+		}
+	}
+	for i, term := range []string{"Short-term", "Long-term", ""} {
+		r := map[string]string{
+			"Symbol":          "Total",
+			"Cost Basis":      totals[i].basis.Fixed(2),
+			"Market Value":    totals[i].value.Fixed(2),
+			"Unrealized Gain": totals[i].gain.Fixed(2),
+		}
+		if term != "" {
+			r["Term"] = term
+		}
+		records = append(records, r)
+	}
+	return report(os.Stdout, unrealizedColumns, records, q)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// The columns of unvested award tables, as variously labelled, and
+// the keys they are given as.
+var unvestedKeys = map[string]string{
+	"Vest Date":       "Vest Date",
+	"Vesting Date":    "Vest Date",
+	"Shares":          "Shares",
+	"Units":           "Shares",
+	"Quantity":        "Shares",
+	"Unvested Shares": "Shares",
+	"Shares Vesting":  "Shares",
+	"Award ID":        "Award ID",
+	"Award Number":    "Award ID",
+	"Grant ID":        "Award ID",
+	"Grant Id":        "Award ID",
+	"Grant Number":    "Award ID",
+	"Award Date":      "Award Date",
+	"Grant Date":      "Award Date",
+	"Award Type":      "Type",
+	"Grant Type":      "Type",
+	"Type":            "Type",
+	"Symbol":          "Symbol",
+}
+
+// Parse the "Unvested" (or "Future Vesting") page: tables of the
+// vests to come, a row each, with the grant (which may be given
+// only on its first row) and the shares. The result is an entry
+// for each vest, marked with the "Record" "unvested", or nil if the
+// page isn't one.
+func parseUnvested(doc *html.Node) []map[string]string {
+	var entries []map[string]string
+	for _, rows := range headedTables(doc, unvestedKeys) {
+		var last map[string]string
+		for _, e := range rows {
+			if e["Vest Date"] == "" || e["Shares"] == "" {
+				continue
+			}
+			if last != nil && e["Award ID"] == "" {
+				for _, k := range []string{"Award ID", "Award Date", "Type", "Symbol"} {
+					if e[k] == "" {
+						e[k] = last[k]
+					}
+				}
+			}
+			e["Record"] = "unvested"
+			entries = append(entries, e)
+			last = e
+		}
+	}
+	return entries
+}
+
+// Find the tables whose header rows have a date column among keys,
+// and parse their rows, naming the cells by keys. Columns not in
+// keys are dropped.
+func headedTables(doc *html.Node, keys map[string]string) [][]map[string]string {
+	var tables [][]map[string]string
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "table" {
+			if rows := headedTable(n, keys); rows != nil {
+				tables = append(tables, rows)
+				return
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	return tables
+}
+
+func headedTable(t *html.Node, keys map[string]string) []map[string]string {
+	var trs []*html.Node
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			switch {
+			case c.Type != html.ElementNode || c.Data == "table":
+			case c.Data == "tr":
+				trs = append(trs, c)
+			default:
+				walk(c)
+			}
+		}
+	}
+	walk(t)
+	if len(trs) < 2 {
+		return nil
+	}
+
+	cells := func(tr *html.Node) []string {
+		var cs []string
+		for c := tr.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.ElementNode && (c.Data == "td" || c.Data == "th") {
+				cs = append(cs, textContent(c))
+			}
+		}
+		return cs
+	}
+	header := cells(trs[0])
+	dated := false
+	for i, h := range header {
+		header[i] = keys[h]
+		if strings.Contains(header[i], "Date") && header[i] != "Award Date" {
+			dated = true
+		}
+	}
+	if !dated {
+		return nil
+	}
+
+	rows := []map[string]string{}
+	for _, tr := range trs[1:] {
+		e := make(map[string]string)
+		for i, v := range cells(tr) {
+			if i < len(header) && header[i] != "" {
+				e[header[i]] = v
+			}
+		}
+		rows = append(rows, e)
+	}
+	return rows
+}
@@ -0,0 +1,114 @@
+package main
+
+// A vest coalesces a lapse (or release) with the deposit of the
+// shares withheld for taxes into the EAC account, and their sale,
+// as most would think of it: so many shares vested, so many were
+// sold for taxes, and the rest were kept. The entries it was made
+// from are given as they would be otherwise.
+type vest struct {
+	Record   string      `json:"Record"`
+	Date     string      `json:"Date"`
+	Symbol   string      `json:"Symbol"`
+	AwardID  string      `json:"Award ID"`
+	Gross    string      `json:"Gross Shares"`
+	Withheld string      `json:"Shares Sold for Taxes"`
+	Net      string      `json:"Net Shares"`
+	FMV      string      `json:"Fair Market Value"`
+	Taxes    string      `json:"Taxes"`
+	Proceeds string      `json:"Tax Sale Proceeds"`
+	Lapse    interface{} `json:"Lapse"`
+	Deposit  interface{} `json:"Deposit,omitempty"`
+	Sale     interface{} `json:"Sale,omitempty"`
+}
+
+var lapseActions = map[string]bool{"Lapse": true, "Release": true}
+
+// Render the entries, coalescing each lapse with its deposit and
+// tax sale, if any, into a vest. These are matched by date and
+// symbol, and award, where both give it.
+func coalesceVests(entries []map[string]string) []interface{} {
+	used := make([]bool, len(entries))
+	match := func(lapse map[string]string, ok func(map[string]string) bool) map[string]string {
+		for i, e := range entries {
+			if used[i] || !ok(e) || e["Date"] != lapse["Date"] || e["Symbol"] != lapse["Symbol"] {
+				continue
+			}
+			award := e["Award ID"]
+			if award == "" {
+				award = e["Grant Id"]
+			}
+			if award != "" && lapse["Award ID"] != "" && award != lapse["Award ID"] {
+				continue
+			}
+			used[i] = true
+			return e
+		}
+		return nil
+	}
+	one := func(e map[string]string) interface{} {
+		if e == nil {
+			return nil
+		}
+		return render([]map[string]string{e})[0]
+	}
+
+	vests := make(map[int]*vest)
+	for i, e := range entries {
+		if !lapseActions[e["Action"]] {
+			continue
+		}
+		used[i] = true
+		deposit := match(e, func(e map[string]string) bool { return e["Action"] == "Deposit" })
+		sale := match(e, func(e map[string]string) bool { return taxSales[e["Action"]] })
+
+		v := &vest{
+			Record:  "vest",
+			Date:    e["Date"],
+			Symbol:  e["Symbol"],
+			AwardID: e["Award ID"],
+			Gross:   e["Quantity"],
+			Net:     e["Net Shares Deposited"],
+			FMV:     e["Fair Market Value"],
+			Taxes:   e["Taxes"],
+			Lapse:   one(e),
+			Deposit: one(deposit),
+			Sale:    one(sale),
+		}
+		switch {
+		case deposit != nil:
+			v.Withheld = deposit["Quantity"]
+		case sale != nil:
+			v.Withheld = sale["Shares"]
+			if v.Withheld == "" {
+				v.Withheld = sale["Quantity"]
+			}
+		}
+		if sale != nil {
+			v.Proceeds = sale["Amount"]
+		}
+		// Whichever of net and withheld shares is missing is the
+		// rest of the gross.
+		gross, ok := amount(e, "Quantity")
+		net, nerr := parseDecimal(v.Net)
+		withheld, werr := parseDecimal(v.Withheld)
+		switch {
+		case !ok:
+		case v.Net == "" && werr == nil:
+			v.Net = gross.Sub(withheld).String()
+		case v.Withheld == "" && nerr == nil:
+			v.Withheld = gross.Sub(net).String()
+		}
+		vests[i] = v
+	}
+
+	var out []interface{}
+	for i, e := range entries {
+		switch {
+		case vests[i] != nil:
+			out = append(out, vests[i])
+		case !used[i]:
+			out = append(out, one(e))
+		}
+	}
+	return out
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// The default wash sale window: a loss is disallowed if
+// substantially identical shares are acquired within 30 days
+// before or after the sale.
+const washDays = 30
+
+// WashConfig configures wash sale detection. It is given in JSON,
+// e.g.:
+//
+//	{"window": 30, "identical": [["GOOG", "GOOGL"], ["FB", "META"]]}
+//
+// Each group in identical lists symbols that are substantially
+// identical to each other, such as dual-class shares or a ticker
+// before and after a rename.
+type WashConfig struct {
+	Window    int        `json:"window"`
+	Identical [][]string `json:"identical"`
+}
+
+func loadWashConfig(file string) (*WashConfig, error) {
+	c := &WashConfig{Window: washDays}
+	if file == "" {
+		return c, nil
+	}
+	b, err := readInput(file)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	if c.Window < 0 {
+		return nil, fmt.Errorf("%s: negative window", file)
+	}
+	return c, nil
+}
+
+// Configure the book for wash sale detection.
+func (c *WashConfig) Configure(b *Book) {
+	b.Window = c.Window
+	b.identical = make(map[string]string)
+	for _, group := range c.Identical {
+		for _, sym := range group {
+			b.identical[sym] = group[0]
+		}
+	}
+}
+
+// Report whether two symbols are substantially identical.
+func (b *Book) Identical(a, c string) bool {
+	if x, ok := b.identical[a]; ok {
+		a = x
+	}
+	if x, ok := b.identical[c]; ok {
+		c = x
+	}
+	return a == c
+}
+
+// A Wash is a loss sale, or part of one, whose loss is disallowed
+// because of a replacement lot. The disallowed loss is added to
+// the replacement's basis, and the holding period of the shares
+// sold is added to the replacement's.
+type Wash struct {
+	Sale        *Sale
+	Replacement *Lot
+	Shares      Decimal
+	Disallowed  Decimal
+
+	// The replacement's adjusted basis and holding period.
+	Basis Decimal
+	Since time.Time
+}
+
+// Look for replacements for a loss sale among the lots of the same
+// owner (see owner), or of any owner in a household, acquired
+// within the window, and disallow the loss accordingly. Shares
+// vested or exercised with those sold, as when some of a vest is
+// sold for its taxes, are not replacements.
+func (b *Book) wash(s *Sale) {
+	lo := s.Sold.AddDate(0, 0, -b.Window)
+	hi := s.Sold.AddDate(0, 0, b.Window)
+	loss := s.Gain().Neg()
+
+	remaining := s.Shares
+	for i := 0; i < len(b.Lots) && remaining.Sign() > 0; i++ {
+		r := b.Lots[i]
+		if r == s.lot || r.replaced || !b.Identical(r.Symbol, s.Symbol) || r.Open.Sign() == 0 {
+			continue
+		}
+		if r.Acquired.Before(lo) || r.Acquired.After(hi) {
+			continue
+		}
+		if !b.Household && r.Owner != "" && s.lot.Owner != "" && r.Owner != s.lot.Owner {
+			continue
+		}
+		if r.Award != "" && r.Award == s.lot.Award && r.Acquired.Equal(s.lot.Acquired) {
+			continue
+		}
+
+		n := remaining
+		if r.Open.Cmp(n) < 0 {
+			n = r.Open
+		} else if r.Open.Cmp(n) > 0 {
+			b.split(r, r.Open.Sub(n))
+		}
+		r.replaced = true
+
+		disallowed := loss.Mul(n).Quo(s.Shares)
+		r.Basis = r.Basis.Add(disallowed)
+		r.Since = r.Since.Add(-s.Sold.Sub(s.Acquired))
+		s.Disallowed = s.Disallowed.Add(disallowed)
+		b.Washes = append(b.Washes, &Wash{s, r, n, disallowed, r.Basis, r.Since})
+		remaining = remaining.Sub(n)
+	}
+}
+
+func formatDate(t time.Time) string {
+	return t.Format("01/02/2006")
+}
+
+// Run the entries from the given sources through a book
+// configured for wash sale detection, writing out the open lots
+// if asked.
+func book(args []string) (*Book, error) {
+	entries, err := load(args)
+	if err != nil {
+		return nil, err
+	}
+	c, err := loadWashConfig(*washConfigFlag)
+	if err != nil {
+		return nil, err
+	}
+
+	b := new(Book)
+	c.Configure(b)
+	if err := b.Run(entries); err != nil {
+		return nil, err
+	}
+
+	if *lotsFlag != "" {
+		f, err := os.Create(*lotsFlag)
+		if err != nil {
+			return nil, err
+		}
+		err = writeLots(f, b)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// Report wash sales across all the given sources.
+func washCommand(args []string) error {
+	q, err := parseQuery(*queryFlag)
+	if err != nil {
+		return err
+	}
+	b, err := book(args)
+	if err != nil {
+		return err
+	}
+
+	var records []map[string]string
+	for _, w := range b.Washes {
+		records = append(records, map[string]string{
+			"Date":               formatDate(w.Sale.Sold),
+			"Symbol":             w.Sale.Symbol,
+			"Source":             w.Sale.Source,
+			"Shares":             w.Shares.String(),
+			"Loss":               w.Sale.Gain().Neg().Mul(w.Shares).Quo(w.Sale.Shares).Fixed(2),
+			"Disallowed":         w.Disallowed.Fixed(2),
+			"Replacement Date":   formatDate(w.Replacement.Acquired),
+			"Replacement Source": w.Replacement.Source,
+			"Replacement Basis":  w.Basis.Fixed(2),
+			"Replacement Since":  formatDate(w.Since),
+		})
+	}
+	return emit(os.Stdout, records, q)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"os"
+	"sort"
+	"time"
+)
+
+// The columns of the wash-risk report.
+var washRiskColumns = []string{"Symbol", "Vest Date", "Shares", "Status", "Avoid From", "Avoid Until"}
+
+// Report the vests, recent or to come (from the unvested awards
+// pages among the files), whose wash sale windows reach -date (by
+// default, today) or later: a sale at a loss of the symbol from
+// "Avoid From" to "Avoid Until" would be washed by the vest, so
+// discretionary sales are best timed outside them. The window is
+// that of -wash-config.
+func washRiskCommand(args []string) error {
+	q, err := parseQuery(*queryFlag)
+	if err != nil {
+		return err
+	}
+	date, err := flagDate()
+	if err != nil {
+		return err
+	}
+	c, err := loadWashConfig(*washConfigFlag)
+	if err != nil {
+		return err
+	}
+	entries, err := load(args)
+	if err != nil {
+		return err
+	}
+
+	type vest struct {
+		symbol string
+		date   time.Time
+		shares Decimal
+		status string
+	}
+	var (
+		vests []*vest
+		seen  = make(map[string]*vest)
+	)
+	for _, e := range entries {
+		status, when := "vested", e["Date"]
+		switch {
+		case e["Record"] == "unvested":
+			status, when = "upcoming", e["Vest Date"]
+		case !lapseActions[e["Action"]]:
+			continue
+		}
+		d, err := parseDate(when)
+		if err != nil || d.AddDate(0, 0, c.Window).Before(date) {
+			continue
+		}
+		shares, _ := first(e, sharesKeys)
+		k := e["Symbol"] + " " + formatDate(d)
+		if v := seen[k]; v != nil {
+			v.shares = v.shares.Add(shares)
+			continue
+		}
+		v := &vest{symbol: e["Symbol"], date: d, shares: shares, status: status}
+		seen[k] = v
+		vests = append(vests, v)
+	}
+	sort.SliceStable(vests, func(i, j int) bool {
+		return vests[i].date.Before(vests[j].date)
+	})
+
+	var records []map[string]string
+	for _, v := range vests {
+		records = append(records, map[string]string{
+			"Symbol":      v.symbol,
+			"Vest Date":   formatDate(v.date),
+			"Shares":      v.shares.String(),
+			"Status":      v.status,
+			"Avoid From":  formatDate(v.date.AddDate(0, 0, -c.Window)),
+			"Avoid Until": formatDate(v.date.AddDate(0, 0, c.Window)),
+		})
+	}
+	return report(os.Stdout, washRiskColumns, records, q)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+var taxCategories = []string{"Federal", "State", "FICA", "Local", "Other"}
+
+// Classify a detail key as a kind of tax withheld, or "" if it
+// isn't one. Generic keys, such as "Taxes", are "Other".
+func taxCategory(key string) string {
+	k := strings.ToLower(key)
+	switch {
+	case strings.Contains(k, "taxable"):
+		return ""
+	case strings.Contains(k, "social security"), strings.Contains(k, "medicare"), strings.Contains(k, "fica"):
+		return "FICA"
+	case !strings.Contains(k, "tax") && !strings.Contains(k, "withh"):
+		return ""
+	case strings.Contains(k, "federal"):
+		return "Federal"
+	case strings.Contains(k, "state"):
+		return "State"
+	case strings.Contains(k, "local"), strings.Contains(k, "city"):
+		return "Local"
+	}
+	return "Other"
+}
+
+// The taxes withheld according to an entry, by category. Generic
+// amounts are only counted if there is no breakdown, as they are
+// likely totals. Amounts converted with -fx are not counted again.
+func withheld(e map[string]string) map[string]Decimal {
+	taxes := make(map[string]Decimal)
+	var other Decimal
+	for k := range e {
+		cat := taxCategory(k)
+		if cat == "" || fxKey(e, k) {
+			continue
+		}
+		v, ok := amount(e, k)
+		if !ok {
+			continue
+		}
+		if cat == "Other" {
+			other = other.Add(v)
+		} else {
+			taxes[cat] = taxes[cat].Add(v)
+		}
+	}
+	if len(taxes) == 0 && other.Sign() != 0 {
+		taxes["Other"] = other
+	}
+	return taxes
+}
+
+// The taxes withheld by each of the entries, by category (see
+// withheld). Where the details don't break them down, the net
+// proceeds of the sales that pay the taxes (Forced Quick Sells, or
+// Sells to Cover) are counted as "Other".
+func withholdings(entries []map[string]string) []map[string]Decimal {
+	taxes := make([]map[string]Decimal, len(entries))
+	broken := make(map[string]bool)
+	for i, e := range entries {
+		if taxes[i] = withheld(e); len(taxes[i]) > 0 {
+			broken[e["Date"]+" "+e["Symbol"]] = true
+		}
+	}
+	for i, e := range entries {
+		if !taxSales[e["Action"]] || broken[e["Date"]+" "+e["Symbol"]] {
+			continue
+		}
+		if v, ok := amount(e, "Amount"); ok {
+			taxes[i] = map[string]Decimal{"Other": v}
+		}
+	}
+	return taxes
+}
+
+// Report the taxes withheld per quarter and per year (see
+// withholdings).
+func withholdingCommand(args []string) error {
+	q, err := parseQuery(*queryFlag)
+	if err != nil {
+		return err
+	}
+	entries, err := load(args)
+	if err != nil {
+		return err
+	}
+
+	totals := make(map[string]map[string]Decimal)
+	add := func(period string, taxes map[string]Decimal) {
+		if totals[period] == nil {
+			totals[period] = make(map[string]Decimal)
+		}
+		for cat, v := range taxes {
+			totals[period][cat] = totals[period][cat].Add(v)
+		}
+	}
+	record := func(e map[string]string, taxes map[string]Decimal) error {
+		date, err := parseDate(e["Date"])
+		if err != nil {
+			return err
+		}
+		if *yearFlag != 0 && date.Year() != *yearFlag {
+			return nil
+		}
+		add(fmt.Sprint(date.Year()), taxes)
+		add(fmt.Sprintf("%d Q%d", date.Year(), (int(date.Month())+2)/3), taxes)
+		return nil
+	}
+
+	for i, taxes := range withholdings(entries) {
+		if len(taxes) == 0 {
+			continue
+		}
+		if err := record(entries[i], taxes); err != nil {
+			return err
+		}
+	}
+
+	var periods []string
+	for p := range totals {
+		periods = append(periods, p)
+	}
+	sort.Strings(periods)
+
+	var records []map[string]string
+	for _, p := range periods {
+		r := map[string]string{"Period": p}
+		var total Decimal
+		for _, cat := range taxCategories {
+			r[cat] = totals[p][cat].Fixed(2)
+			total = total.Add(totals[p][cat])
+		}
+		r["Total"] = total.Fixed(2)
+		records = append(records, r)
+	}
+	return emit(os.Stdout, records, q)
+}